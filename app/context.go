@@ -1,20 +1,48 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/backup"
 	"github.com/mooncorn/gshub-server-api/config"
+	"github.com/mooncorn/gshub-server-api/cycles"
+	"github.com/mooncorn/gshub-server-api/events"
+	"github.com/mooncorn/gshub-server-api/files"
+	"github.com/mooncorn/gshub-server-api/history"
 	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/mods"
+	"github.com/mooncorn/gshub-server-api/scheduler"
 	"github.com/mooncorn/gshub-server-api/service"
 	"github.com/mooncorn/gshub-server-api/system"
 	"gorm.io/gorm"
 )
 
+// How long the instance keeps retrying to fetch its initialization data before giving up
+const startupTimeout = 2 * time.Minute
+
+// How long failed burned cycles are kept after the main api acknowledged them
+const acknowledgedReportRetention = 7 * 24 * time.Hour
+
 type Context struct {
 	DB                *gorm.DB
-	BurnedCycles      uint
+	SessionID         string
+	CycleMeter        *cycles.Meter
+	CyclePricing      cycles.Pricing
+	Checkpointer      *cycles.Checkpointer
+	CycleAlerter      *cycles.Alerter
+	TopUpPoller       *cycles.TopUpPoller
+	IdleMonitor       *cycles.IdleMonitor
+	ConsoleArchiver   *history.ConsoleArchiver
+	EventEngine       *events.Engine
+	EventWebhooks     *events.WebhookSender
+	Backups           *backup.Manager
+	Files             *files.Manager
+	Mods              *mods.Manager
+	Scheduler         *scheduler.Scheduler
 	StartupPayload    *internal.StartupPayload
 	ServiceController *service.ServiceController
 	SystemController  *system.AmazonLinuxSystemController
@@ -24,25 +52,46 @@ type Context struct {
 func NewContext(dbInstance *gorm.DB) *Context {
 	client := internal.NewClient()
 
-	// get failed burned cycles total amount if any
-	var sum uint
-	if err := dbInstance.Find(&internal.FailedBurnedCycle{}).Select("SUM(amount)").Row().Scan(&sum); err != nil {
-		log.Fatalf("failed to get failed burned cycles total amount")
+	// turn checkpoints of sessions that did not exit cleanly into failed burned cycles
+	recovered, err := cycles.RecoverCheckpoints(dbInstance)
+	if err != nil {
+		log.Fatalf("failed to recover cycle checkpoints: %v", err)
 	}
 
-	fmt.Printf("Failed burned cycles total amount: %d", sum)
+	if recovered > 0 {
+		fmt.Printf("Recovered burned cycles from checkpoints: %d\n", recovered)
+	}
+
+	sessionID, err := cycles.NewSessionID()
+	if err != nil {
+		log.Fatalf("failed to start session: %v", err)
+	}
+
+	// get failed burned cycles not yet acknowledged by the main api
+	pendingReports, err := cycles.PendingReports(dbInstance)
+	if err != nil {
+		log.Fatalf("failed to get failed burned cycles: %v", err)
+	}
+
+	fmt.Printf("Failed burned cycles pending reports: %d\n", len(pendingReports))
 
 	// fetch init data
-	startupPayload, err := client.PostStartup(sum)
+	startupCtx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+
+	startupPayload, err := client.PostStartup(startupCtx, cycles.ToCycleReports(pendingReports))
 	if err != nil {
 		log.Fatalf("failed to fetch service data: %v", err)
 	}
 
-	// delete all failed burned cycles
-	// if this fails both the instance and main api state will be unsynchronized
-	// meaning that on the next startup there will be a duplication of failed burned cycles
-	if err = dbInstance.Where("1 = 1").Delete(&internal.FailedBurnedCycle{}).Error; err != nil {
-		log.Fatalf("failed to delete all failed burned cycles: %v", err)
+	// only reports confirmed by the main api are acknowledged, the rest are resent on the next startup
+	// the main api deduplicates them by idempotency key, so resending never bills twice
+	if err = cycles.AcknowledgeReports(dbInstance, startupPayload.AcknowledgedReports); err != nil {
+		log.Printf("failed to acknowledge failed burned cycles: %v", err)
+	}
+
+	if err = cycles.PruneAcknowledgedReports(dbInstance, acknowledgedReportRetention); err != nil {
+		log.Printf("failed to prune failed burned cycles: %v", err)
 	}
 
 	serviceController, err := service.NewServiceController(&service.InstanceData{
@@ -53,9 +102,57 @@ func NewContext(dbInstance *gorm.DB) *Context {
 		log.Fatalf("failed to create the service controller: %v", err)
 	}
 
+	alertThresholds, err := cycles.ParseAlertThresholds(config.Env.LowCycleAlerts)
+	if err != nil {
+		log.Fatalf("failed to parse low cycle alerts: %v", err)
+	}
+
+	cycleMeter := cycles.NewMeter(startupPayload.Cycles)
+
+	// console events are sent to the webhooks, and players coming and going keep the server from being idle.
+	// Games that can not be asked for their players are counted from the events.
+	eventWebhooks := events.NewWebhookSender(events.ParseWebhookUrls(config.Env.EventWebhooks), config.Env.EventWebhookSecret, config.Env.InstanceId)
+	eventEngine := events.NewEngine(serviceController)
+	idleMonitor := cycles.NewIdleMonitor(serviceController, eventEngine, client, time.Duration(config.Env.IdleTimeout)*time.Second, config.Env.IdleShutdown)
+	eventEngine.OnEvent(eventWebhooks.Send)
+	eventEngine.OnEvent(func(event events.Event) {
+		switch event.Type {
+		case service.EventPlayerJoined, service.EventPlayerLeft, service.EventServerReady:
+			idleMonitor.Activity()
+		}
+	})
+
+	// the main api chooses where the backups of this instance are kept, they are staged on the local disk until uploaded
+	backupStorage, err := backup.NewStorage(startupPayload.BackupStorage, backup.DefaultBackupDir)
+	if err != nil {
+		// the instance keeps working with its backups on the local disk until the storage is fixed
+		log.Printf("failed to create backup storage, keeping backups locally: %v", err)
+		backupStorage, _ = backup.NewStorage(internal.BackupStorage{Driver: internal.BackupStorageLocal}, backup.DefaultBackupDir)
+	}
+
+	backups := backup.NewManager(dbInstance, serviceController, backupStorage, backup.DefaultBackupDir, time.Duration(config.Env.BackupInterval)*time.Second, backup.Retention{
+		MaxCount: config.Env.BackupMaxCount,
+		MaxAge:   time.Duration(config.Env.BackupMaxAge) * time.Second,
+	})
+
+	fileManager := files.NewManager(serviceController, files.DefaultMaxTextSize, files.DefaultMaxUploadSize)
+
 	return &Context{
 		DB:                dbInstance,
-		BurnedCycles:      0,
+		SessionID:         sessionID,
+		CycleMeter:        cycleMeter,
+		CyclePricing:      cycles.NewPricing(startupPayload.Pricing),
+		Checkpointer:      cycles.NewCheckpointer(dbInstance, cycleMeter, sessionID, cycles.DefaultCheckpointInterval),
+		CycleAlerter:      cycles.NewAlerter(alertThresholds),
+		TopUpPoller:       cycles.NewTopUpPoller(dbInstance, client, cycleMeter, cycles.DefaultTopUpPollInterval),
+		IdleMonitor:       idleMonitor,
+		ConsoleArchiver:   history.NewConsoleArchiver(serviceController, history.DefaultArchiveDir, history.DefaultMaxFileSize, history.DefaultMaxFiles),
+		EventEngine:       eventEngine,
+		EventWebhooks:     eventWebhooks,
+		Backups:           backups,
+		Files:             fileManager,
+		Mods:              mods.NewManager(serviceController, fileManager, mods.DefaultRestartDelay),
+		Scheduler:         scheduler.NewScheduler(dbInstance, serviceController, backups),
 		StartupPayload:    startupPayload,
 		ServiceController: serviceController,
 		SystemController:  system.NewAmazonLinuxSystemController(),
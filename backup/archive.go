@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+const (
+	// Suffix of the directory a volume is restored into before it replaces the current one
+	restoringSuffix = ".restoring"
+	// Suffix the current directory of a volume is moved to while the restored one takes its place
+	replacedSuffix = ".replaced"
+)
+
+// Name of the directory holding the files of the volume inside the archive, based on its path in the container
+func volumePrefix(volume service.VolumeBinding) string {
+	prefix := strings.Trim(path.Clean("/"+volume.Container), "/")
+	if prefix == "" {
+		return "root"
+	}
+	return prefix
+}
+
+// Writes the host directories of the volumes into a gzip compressed tar archive.
+// Volumes whose host directory does not exist yet are skipped.
+func writeArchive(w io.Writer, volumes []service.VolumeBinding) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, volume := range volumes {
+		if err := addVolume(tw, volume); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress archive: %v", err)
+	}
+	return nil
+}
+
+func addVolume(tw *tar.Writer, volume service.VolumeBinding) error {
+	prefix := volumePrefix(volume)
+
+	if _, err := os.Stat(volume.Host); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return filepath.WalkDir(volume.Host, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return fmt.Errorf("failed to read link %s: %v", file, err)
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			// sockets and other special files are not part of a world
+			return nil
+		}
+
+		rel, err := filepath.Rel(volume.Host, file)
+		if err != nil {
+			return err
+		}
+
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if entry.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write archive: %v", err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", file, err)
+		}
+		defer f.Close()
+
+		// the header size was taken before, a file still growing must not overflow its entry
+		if _, err := io.CopyN(tw, f, header.Size); err != nil {
+			return fmt.Errorf("failed to archive %s: %v", file, err)
+		}
+		return nil
+	})
+}
+
+// Replaces the host directories of the volumes with their content in the archive.
+// The archive is extracted next to the current directories first, so a broken archive leaves them untouched.
+func extractArchive(r io.Reader, volumes []service.VolumeBinding) error {
+	targets := make(map[string]string, len(volumes))
+	for _, volume := range volumes {
+		restoring := filepath.Clean(volume.Host) + restoringSuffix
+		if err := os.RemoveAll(restoring); err != nil {
+			return fmt.Errorf("failed to clean up %s: %v", restoring, err)
+		}
+		targets[volumePrefix(volume)] = restoring
+	}
+
+	cleanup := func() {
+		for _, restoring := range targets {
+			os.RemoveAll(restoring)
+		}
+	}
+
+	if err := extractEntries(r, targets); err != nil {
+		cleanup()
+		return err
+	}
+
+	// the replaced directories are kept until every volume is restored, so a failed swap can put all of them back
+	var swapped []string
+	for _, volume := range volumes {
+		current := filepath.Clean(volume.Host)
+		if err := swapDirectory(current, targets[volumePrefix(volume)]); err != nil {
+			for i := len(swapped) - 1; i >= 0; i-- {
+				rollbackDirectory(swapped[i])
+			}
+			cleanup()
+			return err
+		}
+		swapped = append(swapped, current)
+	}
+
+	for _, current := range swapped {
+		replaced := current + replacedSuffix
+		if err := os.RemoveAll(replaced); err != nil {
+			return fmt.Errorf("failed to remove the replaced files of %s: %v", current, err)
+		}
+	}
+
+	return nil
+}
+
+func extractEntries(r io.Reader, targets map[string]string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %v", err)
+		}
+
+		root, target, ok := entryTarget(header.Name, targets)
+		if !ok {
+			// the volume is no longer declared by the service
+			continue
+		}
+
+		if err := extractEntry(tr, header, root, target); err != nil {
+			return err
+		}
+	}
+
+	// volumes without any entry are restored empty
+	for _, restoring := range targets {
+		if err := os.MkdirAll(restoring, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", restoring, err)
+		}
+	}
+
+	return nil
+}
+
+// Gets the directory of the volume and the path the entry is extracted to, entries escaping their volume are rejected
+func entryTarget(name string, targets map[string]string) (string, string, bool) {
+	name = strings.TrimSuffix(name, "/")
+
+	for prefix, restoring := range targets {
+		if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+			continue
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+		if rel != "" && !isLocalPath(rel) {
+			return "", "", false
+		}
+
+		return restoring, filepath.Join(restoring, filepath.FromSlash(rel)), true
+	}
+
+	return "", "", false
+}
+
+// Checks that the relative slash separated path stays within its directory
+func isLocalPath(rel string) bool {
+	clean := path.Clean(rel)
+	return clean == rel && !path.IsAbs(clean) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+func extractEntry(tr *tar.Reader, header *tar.Header, root string, target string) error {
+	mode := fs.FileMode(header.Mode).Perm()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(target), err)
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode); err != nil {
+			return fmt.Errorf("failed to create %s: %v", target, err)
+		}
+		os.Chmod(target, mode)
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %v", target, err)
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to extract %s: %v", target, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", target, err)
+		}
+	case tar.TypeSymlink:
+		// links leaving the volume could make later entries write outside of it
+		rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(target), header.Linkname))
+		if filepath.IsAbs(header.Linkname) || err != nil || !isLocalPath(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return fmt.Errorf("failed to create link %s: %v", target, err)
+		}
+	default:
+		return nil
+	}
+
+	// the game runs as its own user, the files must stay its own; this only works as root
+	os.Lchown(target, header.Uid, header.Gid)
+	return nil
+}
+
+// Moves the restored directory in place of the current one, which is kept next to it until the restore is done
+func swapDirectory(current string, restoring string) error {
+	replaced := current + replacedSuffix
+	if err := os.RemoveAll(replaced); err != nil {
+		return fmt.Errorf("failed to clean up %s: %v", replaced, err)
+	}
+
+	if err := os.Rename(current, replaced); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to move %s: %v", current, err)
+	}
+
+	if err := os.Rename(restoring, current); err != nil {
+		// put the current files back so the volume is not left empty
+		os.Rename(replaced, current)
+		return fmt.Errorf("failed to restore %s: %v", current, err)
+	}
+	return nil
+}
+
+// Puts the files a swap replaced back in place of the restored ones
+func rollbackDirectory(current string) {
+	os.RemoveAll(current)
+	os.Rename(current+replacedSuffix, current)
+}
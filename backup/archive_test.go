@@ -0,0 +1,330 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+// Creates the files under the directory by their slash separated path
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Reads the regular files under the directory by their slash separated path
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func equalTree(got map[string]string, want map[string]string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for name, content := range want {
+		if got[name] != content {
+			return false
+		}
+	}
+	return true
+}
+
+// Fails when a restore left its working directories next to the volumes
+func assertNoLeftovers(t *testing.T, dir string) {
+	t.Helper()
+
+	for _, suffix := range []string{restoringSuffix, replacedSuffix} {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*"+suffix))
+		if len(matches) != 0 {
+			t.Fatalf("left behind %v", matches)
+		}
+	}
+}
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildArchive(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0o644, Linkname: entry.linkname, Size: int64(len(entry.body))}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0o755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVolumePrefix(t *testing.T) {
+	tests := []struct {
+		container string
+		want      string
+	}{
+		{"/data", "data"},
+		{"/config/worlds/", "config/worlds"},
+		{"data/../saves", "saves"},
+		{"/", "root"},
+		{"", "root"},
+	}
+
+	for _, test := range tests {
+		if got := volumePrefix(service.VolumeBinding{Container: test.container}); got != test.want {
+			t.Errorf("volumePrefix(%q) = %q, want %q", test.container, got, test.want)
+		}
+	}
+}
+
+func TestIsLocalPath(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"world", true},
+		{"world/region/r.0.0.mca", true},
+		{"..world", true},
+		{"..", false},
+		{"../world", false},
+		{"world/../../etc", false},
+		{"world/../level.dat", false},
+		{"./world", false},
+		{"world/", false},
+		{"/etc/passwd", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := isLocalPath(test.rel); got != test.want {
+			t.Errorf("isLocalPath(%q) = %v, want %v", test.rel, got, test.want)
+		}
+	}
+}
+
+func TestEntryTarget(t *testing.T) {
+	targets := map[string]string{
+		"data":          "/srv/data.restoring",
+		"config/worlds": "/srv/worlds.restoring",
+	}
+
+	tests := []struct {
+		name       string
+		wantTarget string
+		wantOK     bool
+	}{
+		{"data/", "/srv/data.restoring", true},
+		{"data", "/srv/data.restoring", true},
+		{"data/world/level.dat", "/srv/data.restoring/world/level.dat", true},
+		{"config/worlds/Dedicated.db", "/srv/worlds.restoring/Dedicated.db", true},
+		{"config/other.cfg", "", false},
+		{"database/level.dat", "", false},
+		{"logs/latest.log", "", false},
+		{"data/../etc/passwd", "", false},
+		{"data/world/../../../etc/passwd", "", false},
+		{"data//level.dat", "", false},
+	}
+
+	for _, test := range tests {
+		root, target, ok := entryTarget(test.name, targets)
+		if ok != test.wantOK || target != test.wantTarget {
+			t.Errorf("entryTarget(%q) = %q, %v, want %q, %v", test.name, target, ok, test.wantTarget, test.wantOK)
+			continue
+		}
+		if ok && root != targets["data"] && root != targets["config/worlds"] {
+			t.Errorf("entryTarget(%q) root = %q, want the restoring directory of the volume", test.name, root)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	volumes := []service.VolumeBinding{
+		{Container: "/data", Host: filepath.Join(dir, "data")},
+		{Container: "/config", Host: filepath.Join(dir, "config")},
+		// declared but never created by the game
+		{Container: "/mods", Host: filepath.Join(dir, "mods")},
+	}
+
+	data := map[string]string{"level.dat": "level", "world/region/r.0.0.mca": "region"}
+	config := map[string]string{"server.properties": "motd=hello"}
+	writeTree(t, volumes[0].Host, data)
+	writeTree(t, volumes[1].Host, config)
+	if err := os.Symlink("world/region", filepath.Join(volumes[0].Host, "region")); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := writeArchive(&archive, volumes); err != nil {
+		t.Fatalf("writeArchive: %v", err)
+	}
+
+	// the game keeps playing after the backup
+	writeTree(t, volumes[0].Host, map[string]string{"level.dat": "changed", "world/new.mca": "new"})
+	writeTree(t, volumes[2].Host, map[string]string{"mod.jar": "mod"})
+
+	if err := extractArchive(&archive, volumes); err != nil {
+		t.Fatalf("extractArchive: %v", err)
+	}
+
+	if got := readTree(t, volumes[0].Host); !equalTree(got, data) {
+		t.Fatalf("data = %v, want %v", got, data)
+	}
+	if got := readTree(t, volumes[1].Host); !equalTree(got, config) {
+		t.Fatalf("config = %v, want %v", got, config)
+	}
+	if got := readTree(t, volumes[2].Host); len(got) != 0 {
+		t.Fatalf("mods = %v, want the volume restored empty", got)
+	}
+	if link, err := os.Readlink(filepath.Join(volumes[0].Host, "region")); err != nil || link != "world/region" {
+		t.Fatalf("link = %q, %v, want world/region", link, err)
+	}
+	assertNoLeftovers(t, dir)
+}
+
+func TestExtractArchiveConfinesEntries(t *testing.T) {
+	dir := t.TempDir()
+	host := filepath.Join(dir, "volumes", "data")
+	volumes := []service.VolumeBinding{{Container: "/data", Host: host}}
+
+	archive := buildArchive(t, []tarEntry{
+		{name: "data/", typeflag: tar.TypeDir},
+		{name: "data/level.dat", typeflag: tar.TypeReg, body: "level"},
+		{name: "data/../escaped", typeflag: tar.TypeReg, body: "escaped"},
+		{name: "data/world/../../../escaped", typeflag: tar.TypeReg, body: "escaped"},
+		// a link leaving the volume followed by an entry written through it
+		{name: "data/out", typeflag: tar.TypeSymlink, linkname: "../.."},
+		{name: "data/out/escaped", typeflag: tar.TypeReg, body: "escaped"},
+		{name: "data/abs", typeflag: tar.TypeSymlink, linkname: dir},
+		{name: "data/inside", typeflag: tar.TypeSymlink, linkname: "level.dat"},
+		{name: "other/file", typeflag: tar.TypeReg, body: "not a volume"},
+	})
+
+	if err := extractArchive(bytes.NewReader(archive), volumes); err != nil {
+		t.Fatalf("extractArchive: %v", err)
+	}
+
+	for _, name := range []string{"escaped", "volumes/escaped", "other"} {
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("%s was written outside of the volume", name)
+		}
+	}
+	if _, err := os.Readlink(filepath.Join(host, "abs")); err == nil {
+		t.Fatal("an absolute link was restored")
+	}
+	if link, err := os.Readlink(filepath.Join(host, "inside")); err != nil || link != "level.dat" {
+		t.Fatalf("link = %q, %v, want level.dat", link, err)
+	}
+
+	want := map[string]string{"level.dat": "level", "out/escaped": "escaped"}
+	if got := readTree(t, host); !equalTree(got, want) {
+		t.Fatalf("volume = %v, want %v", got, want)
+	}
+}
+
+func TestExtractBrokenArchiveKeepsVolumes(t *testing.T) {
+	valid := buildArchive(t, []tarEntry{{name: "data/level.dat", typeflag: tar.TypeReg, body: "restored"}})
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"not gzip", []byte("not an archive")},
+		{"truncated", valid[:len(valid)/2]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			volumes := []service.VolumeBinding{{Container: "/data", Host: filepath.Join(dir, "data")}}
+			current := map[string]string{"level.dat": "current"}
+			writeTree(t, volumes[0].Host, current)
+
+			if err := extractArchive(bytes.NewReader(test.archive), volumes); err == nil {
+				t.Fatal("extractArchive succeeded with a broken archive")
+			}
+			if got := readTree(t, volumes[0].Host); !equalTree(got, current) {
+				t.Fatalf("volume = %v, want it untouched", got)
+			}
+			assertNoLeftovers(t, dir)
+		})
+	}
+}
+
+func TestExtractArchiveRollsBackEveryVolume(t *testing.T) {
+	dir := t.TempDir()
+	// the second volume lives inside the first one, its restored files are moved away by the first swap
+	volumes := []service.VolumeBinding{
+		{Container: "/data", Host: filepath.Join(dir, "world")},
+		{Container: "/saves", Host: filepath.Join(dir, "world", "saves")},
+	}
+
+	current := map[string]string{"level.dat": "current", "saves/slot1": "current save"}
+	writeTree(t, volumes[0].Host, current)
+
+	archive := buildArchive(t, []tarEntry{
+		{name: "data/level.dat", typeflag: tar.TypeReg, body: "restored"},
+		{name: "saves/slot1", typeflag: tar.TypeReg, body: "restored save"},
+	})
+
+	if err := extractArchive(bytes.NewReader(archive), volumes); err == nil {
+		t.Fatal("extractArchive succeeded although a volume could not be swapped")
+	}
+
+	if got := readTree(t, volumes[0].Host); !equalTree(got, current) {
+		t.Fatalf("world = %v, want every volume rolled back to %v", got, current)
+	}
+	assertNoLeftovers(t, dir)
+	assertNoLeftovers(t, volumes[0].Host)
+}
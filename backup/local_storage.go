@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+// Keeps the archives in a directory of the instance, or of a disk mounted on it
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) *localStorage {
+	return &localStorage{dir: dir}
+}
+
+func (s *localStorage) Driver() string {
+	return internal.BackupStorageLocal
+}
+
+func (s *localStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// Appends the rest of the archive to the partial copy left by an interrupted upload, and moves it in place once verified
+func (s *localStorage) Upload(c context.Context, name string, localPath string, checksum string) error {
+	dst := s.path(name)
+	if sameFile(localPath, dst) {
+		// the archive was staged where it is kept
+		return verifyFile(dst, checksum)
+	}
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create backup storage directory: %v", err)
+	}
+
+	partPath := dst + ".part"
+	part, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create stored archive: %v", err)
+	}
+	defer part.Close()
+
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to read stored archive: %v", err)
+	}
+
+	src, err := openAt(localPath, offset)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, err := io.Copy(part, contextReader{c, src}); err != nil {
+		return fmt.Errorf("failed to upload backup archive: %v", err)
+	}
+	if err := part.Sync(); err != nil {
+		return fmt.Errorf("failed to upload backup archive: %v", err)
+	}
+	if err := part.Close(); err != nil {
+		return fmt.Errorf("failed to upload backup archive: %v", err)
+	}
+
+	if err := verifyFile(partPath, checksum); err != nil {
+		// the partial copy is broken, the next attempt starts over
+		os.Remove(partPath)
+		return err
+	}
+
+	if err := os.Rename(partPath, dst); err != nil {
+		return fmt.Errorf("failed to save stored archive: %v", err)
+	}
+	return nil
+}
+
+func (s *localStorage) Open(c context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrBackupNotFound
+		}
+		return nil, fmt.Errorf("failed to open backup archive: %v", err)
+	}
+	return f, nil
+}
+
+func (s *localStorage) Delete(c context.Context, name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete backup archive: %v", err)
+	}
+	os.Remove(s.path(name) + ".part")
+	return nil
+}
+
+func sameFile(a string, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+func verifyFile(path string, checksum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup archive: %v", err)
+	}
+	defer f.Close()
+
+	sum, err := readChecksum(f)
+	if err != nil {
+		return fmt.Errorf("failed to read backup archive: %v", err)
+	}
+	if sum != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Stops a copy when the context is done
+type contextReader struct {
+	c context.Context
+	r io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.c.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Stops a write when the context is done
+type contextWriter struct {
+	c context.Context
+	w io.Writer
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.c.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
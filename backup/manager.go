@@ -0,0 +1,503 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultBackupDir = "backups"
+
+	// How long a scheduled backup can take before it is abandoned
+	scheduledBackupTimeout = time.Hour
+	// How long resuming the game's writes can take, also after the backup was canceled
+	finishBackupTimeout = 30 * time.Second
+	// How often uploads that failed are tried again
+	uploadRetryInterval = 5 * time.Minute
+	// Suffix of an archive downloaded from the storage for a restore
+	downloadSuffix = ".download"
+)
+
+var (
+	ErrBackupNotFound = errors.New("backup not found")
+	// Returned when a backup or a restore is started while another one runs
+	ErrBackupInProgress = errors.New("another backup or restore is in progress")
+	// Returned when the archive of a backup does not match the checksum taken when it was created
+	ErrChecksumMismatch = errors.New("backup archive does not match its checksum")
+)
+
+// Target is the part of the service controller backups rely on
+type Target interface {
+	GetVolumes(c context.Context) ([]service.VolumeBinding, error)
+	PrepareBackup(c context.Context) error
+	FinishBackup(c context.Context) error
+	IsRunning(c context.Context) (bool, error)
+	GracefulStop(c context.Context) error
+	StartService(c context.Context) error
+}
+
+// Retention decides which backups are deleted after a new one is made, zero values keep everything
+type Retention struct {
+	// Number of backups to keep
+	MaxCount int
+	// Backups older than this are deleted
+	MaxAge time.Duration
+}
+
+// Manager makes backups of the game files on demand and on a schedule, and restores them.
+// Archives are written to the local directory first and kept there until they reach the storage.
+type Manager struct {
+	db        *gorm.DB
+	target    Target
+	storage   Storage
+	dir       string
+	interval  time.Duration
+	retention Retention
+
+	// only one backup or restore runs at a time
+	mu sync.Mutex
+
+	// cancels the scheduled backup or upload in progress when the manager stops, an interrupted upload resumes later
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewManager(db *gorm.DB, target Target, storage Storage, dir string, interval time.Duration, retention Retention) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		ctx:       ctx,
+		cancel:    cancel,
+		db:        db,
+		target:    target,
+		storage:   storage,
+		dir:       dir,
+		interval:  interval,
+		retention: retention,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Starts making backups on the schedule and retrying failed uploads, a zero interval only allows backups on demand
+func (m *Manager) Start() {
+	go m.run()
+}
+
+// Stops the schedule, interrupting the scheduled backup or upload in progress
+func (m *Manager) Stop() {
+	m.cancel()
+	close(m.stop)
+	<-m.done
+}
+
+func (m *Manager) run() {
+	defer close(m.done)
+
+	// backups left over from the last run are uploaded first
+	m.retryUploads()
+
+	var schedule <-chan time.Time
+	if m.interval > 0 {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		schedule = ticker.C
+	}
+
+	retry := time.NewTicker(uploadRetryInterval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-schedule:
+			m.scheduledBackup()
+		case <-retry.C:
+			m.retryUploads()
+		}
+	}
+}
+
+func (m *Manager) scheduledBackup() {
+	ctx, cancel := context.WithTimeout(m.ctx, scheduledBackupTimeout)
+	defer cancel()
+
+	backup, err := m.Create(ctx, internal.BackupTriggerScheduled)
+	if err != nil {
+		// there is nothing to back up before a server is created
+		if !errors.Is(err, service.ErrContainerNotFound) {
+			log.Printf("Scheduled backup failed: %v", err)
+		}
+		return
+	}
+
+	log.Printf("Scheduled backup %s created", backup.Name)
+}
+
+// Archives the game files, pausing the game's writes while they are read, and applies the retention
+func (m *Manager) Create(c context.Context, trigger string) (internal.Backup, error) {
+	if !m.mu.TryLock() {
+		return internal.Backup{}, ErrBackupInProgress
+	}
+	defer m.mu.Unlock()
+
+	volumes, err := m.target.GetVolumes(c)
+	if err != nil {
+		return internal.Backup{}, err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return internal.Backup{}, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	name := fmt.Sprintf("backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405.000"))
+	size, checksum, err := m.writeBackup(c, name, volumes)
+	if err != nil {
+		return internal.Backup{}, err
+	}
+
+	backup := internal.Backup{
+		Name:     name,
+		Size:     size,
+		Checksum: checksum,
+		Trigger:  trigger,
+		Storage:  m.storage.Driver(),
+	}
+
+	if err := m.db.Create(&backup).Error; err != nil {
+		os.Remove(m.stagingPath(backup))
+		return internal.Backup{}, fmt.Errorf("failed to save backup: %v", err)
+	}
+
+	// the archive stays staged when the upload fails, it is tried again later
+	if err := m.upload(c, &backup); err != nil {
+		log.Printf("Failed to upload backup %s to %s storage: %v", backup.Name, m.storage.Driver(), err)
+	}
+
+	if err := m.applyRetention(c); err != nil {
+		log.Printf("Failed to apply backup retention: %v", err)
+	}
+
+	return backup, nil
+}
+
+// Writes the archive under a temporary name, so a partial archive is never listed, and returns its size and checksum
+func (m *Manager) writeBackup(c context.Context, name string, volumes []service.VolumeBinding) (int64, string, error) {
+	path := filepath.Join(m.dir, name)
+	partPath := path + ".part"
+
+	f, err := os.Create(partPath)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create backup: %v", err)
+	}
+	defer os.Remove(partPath)
+	defer f.Close()
+
+	if err := m.target.PrepareBackup(c); err != nil {
+		// some of the commands may have run, the game must not be left paused
+		m.finishBackup(c)
+		return 0, "", fmt.Errorf("failed to prepare the game for the backup: %v", err)
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	err = writeArchive(contextWriter{c, io.MultiWriter(f, hash, counter)}, volumes)
+
+	m.finishBackup(c)
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	if err := f.Sync(); err != nil {
+		return 0, "", fmt.Errorf("failed to write backup: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, "", fmt.Errorf("failed to write backup: %v", err)
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return 0, "", fmt.Errorf("failed to save backup: %v", err)
+	}
+
+	return counter.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Uploads the staged archive to the storage and removes the staged copy
+func (m *Manager) upload(c context.Context, backup *internal.Backup) error {
+	if err := m.storage.Upload(c, backup.Name, m.stagingPath(*backup), backup.Checksum); err != nil {
+		return err
+	}
+
+	backup.Storage = m.storage.Driver()
+	backup.Uploaded = true
+	if err := m.db.Model(backup).Select("Storage", "Uploaded").Updates(backup).Error; err != nil {
+		return fmt.Errorf("failed to save backup: %v", err)
+	}
+
+	if !m.storesInStaging() {
+		if err := os.Remove(m.stagingPath(*backup)); err != nil {
+			log.Printf("Failed to remove staged backup %s: %v", backup.Name, err)
+		}
+	}
+	return nil
+}
+
+// Uploads the backups still waiting for their storage, skipped while a backup or restore runs
+func (m *Manager) retryUploads() {
+	if !m.mu.TryLock() {
+		return
+	}
+	defer m.mu.Unlock()
+
+	var pending []internal.Backup
+	if err := m.db.Where("uploaded = ?", false).Order("created_at").Find(&pending).Error; err != nil {
+		log.Printf("Failed to get backups waiting for upload: %v", err)
+		return
+	}
+
+	for _, backup := range pending {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(m.ctx, scheduledBackupTimeout)
+		err := m.upload(ctx, &backup)
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to upload backup %s to %s storage: %v", backup.Name, m.storage.Driver(), err)
+			continue
+		}
+		log.Printf("Backup %s uploaded to %s storage", backup.Name, m.storage.Driver())
+	}
+}
+
+// Whether the storage keeps the archives in the staging directory itself
+func (m *Manager) storesInStaging() bool {
+	local, ok := m.storage.(*localStorage)
+	return ok && filepath.Clean(local.dir) == filepath.Clean(m.dir)
+}
+
+// Resumes the game's writes, also when the backup was canceled
+func (m *Manager) finishBackup(c context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c), finishBackupTimeout)
+	defer cancel()
+
+	if err := m.target.FinishBackup(ctx); err != nil {
+		log.Printf("Failed to resume the game after the backup: %v", err)
+	}
+}
+
+// Gets all backups, newest first
+func (m *Manager) List() ([]internal.Backup, error) {
+	backups := []internal.Backup{}
+	if err := m.db.Order("created_at DESC").Find(&backups).Error; err != nil {
+		return nil, fmt.Errorf("failed to get backups: %v", err)
+	}
+	return backups, nil
+}
+
+func (m *Manager) Get(ID uint) (internal.Backup, error) {
+	var backup internal.Backup
+	if err := m.db.First(&backup, ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return internal.Backup{}, ErrBackupNotFound
+		}
+		return internal.Backup{}, fmt.Errorf("failed to get backup: %v", err)
+	}
+	return backup, nil
+}
+
+// Gets the path the archive of the backup is staged at until it is uploaded
+func (m *Manager) stagingPath(backup internal.Backup) string {
+	return filepath.Join(m.dir, backup.Name)
+}
+
+// Opens the archive of the backup, from the storage once it was uploaded
+func (m *Manager) Open(c context.Context, backup internal.Backup) (io.ReadCloser, error) {
+	if !backup.Uploaded || m.storesInStaging() {
+		f, err := os.Open(m.stagingPath(backup))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open backup archive: %v", err)
+		}
+		return f, nil
+	}
+
+	if backup.Storage != m.storage.Driver() {
+		return nil, fmt.Errorf("backup is kept in %s storage, which is no longer used", backup.Storage)
+	}
+	return m.storage.Open(c, backup.Name)
+}
+
+func (m *Manager) Delete(c context.Context, ID uint) error {
+	backup, err := m.Get(ID)
+	if err != nil {
+		return err
+	}
+	return m.delete(c, backup)
+}
+
+func (m *Manager) delete(c context.Context, backup internal.Backup) error {
+	// an archive that was never uploaded may still have a partial copy in the storage
+	if backup.Storage == m.storage.Driver() {
+		if err := m.storage.Delete(c, backup.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(m.stagingPath(backup)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete backup archive: %v", err)
+	}
+
+	if err := m.db.Delete(&backup).Error; err != nil {
+		return fmt.Errorf("failed to delete backup: %v", err)
+	}
+	return nil
+}
+
+// Replaces the game files with the backup. The game is stopped first and started again if it was running.
+func (m *Manager) Restore(c context.Context, ID uint) error {
+	if !m.mu.TryLock() {
+		return ErrBackupInProgress
+	}
+	defer m.mu.Unlock()
+
+	backup, err := m.Get(ID)
+	if err != nil {
+		return err
+	}
+
+	path, cleanup, err := m.fetch(c, backup)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// a damaged archive is caught before the game is stopped
+	if err := verifyFile(path, backup.Checksum); err != nil {
+		return err
+	}
+
+	volumes, err := m.target.GetVolumes(c)
+	if err != nil {
+		return err
+	}
+
+	running, err := m.target.IsRunning(c)
+	if err != nil {
+		return err
+	}
+
+	if err := m.target.GracefulStop(c); err != nil {
+		return fmt.Errorf("failed to stop the game: %v", err)
+	}
+
+	restoreErr := m.extract(path, volumes)
+
+	if running {
+		if err := m.target.StartService(c); err != nil {
+			if restoreErr != nil {
+				return fmt.Errorf("%v, and failed to start the game: %v", restoreErr, err)
+			}
+			return fmt.Errorf("failed to start the game: %v", err)
+		}
+	}
+
+	return restoreErr
+}
+
+// Gets a local path of the archive, downloading it from the storage when it is no longer staged.
+// The returned function removes the downloaded copy.
+func (m *Manager) fetch(c context.Context, backup internal.Backup) (string, func(), error) {
+	if !backup.Uploaded || m.storesInStaging() {
+		return m.stagingPath(backup), func() {}, nil
+	}
+
+	src, err := m.Open(c, backup)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return "", nil, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	path := m.stagingPath(backup) + downloadSuffix
+	cleanup := func() { os.Remove(path) }
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download backup archive: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, src); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to download backup archive: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to download backup archive: %v", err)
+	}
+
+	return path, cleanup, nil
+}
+
+func (m *Manager) extract(path string, volumes []service.VolumeBinding) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup archive: %v", err)
+	}
+	defer f.Close()
+
+	return extractArchive(f, volumes)
+}
+
+// Deletes the backups beyond the max count and those older than the max age
+func (m *Manager) applyRetention(c context.Context) error {
+	backups, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	for i, backup := range backups {
+		tooMany := m.retention.MaxCount > 0 && i >= m.retention.MaxCount
+		tooOld := m.retention.MaxAge > 0 && time.Since(backup.CreatedAt) > m.retention.MaxAge
+
+		if tooMany || tooOld {
+			if err := m.delete(c, backup); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Counts the bytes written through it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
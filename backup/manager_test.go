@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// hangingStorage never finishes an upload until it is canceled
+type hangingStorage struct {
+	started chan struct{}
+}
+
+func (s *hangingStorage) Driver() string { return internal.BackupStorageS3 }
+
+func (s *hangingStorage) Upload(c context.Context, name string, localPath string, checksum string) error {
+	s.started <- struct{}{}
+	<-c.Done()
+	return c.Err()
+}
+
+func (s *hangingStorage) Open(c context.Context, name string) (io.ReadCloser, error) {
+	return nil, ErrBackupNotFound
+}
+
+func (s *hangingStorage) Delete(c context.Context, name string) error { return nil }
+
+func TestManagerStopInterruptsUpload(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&internal.Backup{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	db.Create(&internal.Backup{Name: "backup-1.tar.gz", Storage: internal.BackupStorageS3})
+
+	storage := &hangingStorage{started: make(chan struct{}, 1)}
+	m := NewManager(db, nil, storage, t.TempDir(), 0, Retention{})
+	m.Start()
+
+	select {
+	case <-storage.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pending backup was not uploaded")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		m.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for the upload in progress")
+	}
+
+	// the upload is tried again on the next start
+	var backup internal.Backup
+	if err := db.First(&backup).Error; err != nil {
+		t.Fatal(err)
+	}
+	if backup.Uploaded {
+		t.Fatal("the interrupted upload was recorded as done")
+	}
+}
@@ -0,0 +1,216 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+const (
+	// Size of the parts archives are uploaded in, S3 needs at least 5MiB for all but the last part
+	s3PartSize = 16 << 20
+	// User metadata holding the sha256 of the whole archive
+	s3ChecksumMetadata = "Sha256"
+)
+
+// Keeps the archives in a bucket of an S3-compatible object storage, such as AWS S3 or MinIO
+type s3Storage struct {
+	core   *minio.Core
+	bucket string
+	prefix string
+}
+
+func newS3Storage(config internal.S3BackupStorage) (*s3Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("s3 backup storage needs an endpoint and a bucket")
+	}
+
+	core, err := minio.NewCore(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %v", err)
+	}
+
+	return &s3Storage{core: core, bucket: config.Bucket, prefix: strings.Trim(config.Prefix, "/")}, nil
+}
+
+func (s *s3Storage) Driver() string {
+	return internal.BackupStorageS3
+}
+
+func (s *s3Storage) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// Uploads the archive in parts, each verified by the storage with its sha256, then downloads it again to verify the whole archive.
+// Parts of an interrupted multipart upload whose md5 matches the local archive are not uploaded again.
+func (s *s3Storage) Upload(c context.Context, name string, localPath string, checksum string) error {
+	key := s.key(name)
+
+	// a previous attempt may have completed without being recorded
+	if info, err := s.core.StatObject(c, s.bucket, key, minio.StatObjectOptions{}); err == nil {
+		if info.UserMetadata[s3ChecksumMetadata] == checksum && s.verify(c, key, checksum) == nil {
+			return nil
+		}
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup archive: %v", err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read backup archive: %v", err)
+	}
+
+	opts := minio.PutObjectOptions{
+		ContentType:  "application/gzip",
+		UserMetadata: map[string]string{s3ChecksumMetadata: checksum},
+	}
+
+	uploadID, uploaded, err := s.resumeUpload(c, key)
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		if uploadID, err = s.core.NewMultipartUpload(c, s.bucket, key, opts); err != nil {
+			return fmt.Errorf("failed to start upload: %v", err)
+		}
+	}
+
+	var parts []minio.CompletePart
+	buf := make([]byte, s3PartSize)
+	for number, offset := 1, int64(0); offset < stat.Size() || number == 1; number, offset = number+1, offset+s3PartSize {
+		n, err := io.ReadFull(f, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read backup archive: %v", err)
+		}
+		data := buf[:n]
+
+		md5Sum := md5.Sum(data)
+		etag := hex.EncodeToString(md5Sum[:])
+		if part, ok := uploaded[number]; ok && part.Size == int64(n) && strings.Trim(part.ETag, `"`) == etag {
+			parts = append(parts, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+			continue
+		}
+
+		sha256Sum := sha256.Sum256(data)
+		part, err := s.core.PutObjectPart(c, s.bucket, key, uploadID, number, bytes.NewReader(data), int64(n), minio.PutObjectPartOptions{
+			Md5Base64: base64.StdEncoding.EncodeToString(md5Sum[:]),
+			Sha256Hex: hex.EncodeToString(sha256Sum[:]),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %v", number, err)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+	}
+
+	if _, err := s.core.CompleteMultipartUpload(c, s.bucket, key, uploadID, parts, opts); err != nil {
+		return fmt.Errorf("failed to complete upload: %v", err)
+	}
+
+	if err := s.verify(c, key, checksum); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			s.core.RemoveObject(c, s.bucket, key, minio.RemoveObjectOptions{})
+		}
+		return err
+	}
+	return nil
+}
+
+// Downloads the stored archive and compares its sha256 with the checksum
+func (s *s3Storage) verify(c context.Context, key string, checksum string) error {
+	object, _, _, err := s.core.GetObject(c, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to check uploaded archive: %v", err)
+	}
+	defer object.Close()
+
+	sum, err := readChecksum(object)
+	if err != nil {
+		return fmt.Errorf("failed to check uploaded archive: %v", err)
+	}
+	if sum != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Finds the multipart upload an interrupted attempt left for the key and the parts it uploaded
+func (s *s3Storage) resumeUpload(c context.Context, key string) (string, map[int]minio.ObjectPart, error) {
+	result, err := s.core.ListMultipartUploads(c, s.bucket, key, "", "", "", 1000)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list unfinished uploads: %v", err)
+	}
+
+	uploadID := ""
+	for _, upload := range result.Uploads {
+		if upload.Key == key {
+			uploadID = upload.UploadID
+		}
+	}
+	if uploadID == "" {
+		return "", nil, nil
+	}
+
+	parts := make(map[int]minio.ObjectPart)
+	marker := 0
+	for {
+		result, err := s.core.ListObjectParts(c, s.bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list uploaded parts: %v", err)
+		}
+		for _, part := range result.ObjectParts {
+			parts[part.PartNumber] = part
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	return uploadID, parts, nil
+}
+
+func (s *s3Storage) Open(c context.Context, name string) (io.ReadCloser, error) {
+	object, _, _, err := s.core.GetObject(c, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrBackupNotFound
+		}
+		return nil, fmt.Errorf("failed to download backup archive: %v", err)
+	}
+	return object, nil
+}
+
+func (s *s3Storage) Delete(c context.Context, name string) error {
+	key := s.key(name)
+
+	// parts of an upload that never completed are billed as well
+	if uploadID, _, err := s.resumeUpload(c, key); err == nil && uploadID != "" {
+		s.core.AbortMultipartUpload(c, s.bucket, key, uploadID)
+	}
+
+	if err := s.core.RemoveObject(c, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete backup archive: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+// Connects to the S3-compatible storage given in the environment, such as a MinIO started with
+//
+//	docker run -p 9000:9000 -e MINIO_ROOT_USER=minio -e MINIO_ROOT_PASSWORD=minio123 minio/minio server /data
+//	BACKUP_TEST_S3_ENDPOINT=localhost:9000 BACKUP_TEST_S3_ACCESS_KEY=minio BACKUP_TEST_S3_SECRET_KEY=minio123 go test ./backup
+//
+// Every test keeps its archives under its own prefix of the gshub-test bucket.
+func newTestS3Storage(t *testing.T) *s3Storage {
+	t.Helper()
+
+	endpoint := os.Getenv("BACKUP_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("BACKUP_TEST_S3_ENDPOINT is not set")
+	}
+
+	storage, err := newS3Storage(internal.S3BackupStorage{
+		Endpoint:  endpoint,
+		Bucket:    "gshub-test",
+		AccessKey: os.Getenv("BACKUP_TEST_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("BACKUP_TEST_S3_SECRET_KEY"),
+		Prefix:    fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatalf("newS3Storage: %v", err)
+	}
+
+	ctx := context.Background()
+	exists, err := storage.core.BucketExists(ctx, storage.bucket)
+	if err != nil {
+		t.Fatalf("failed to reach the storage: %v", err)
+	}
+	if !exists {
+		if err := storage.core.MakeBucket(ctx, storage.bucket, minio.MakeBucketOptions{}); err != nil {
+			t.Fatalf("failed to create bucket: %v", err)
+		}
+	}
+	return storage
+}
+
+func TestS3StorageUpload(t *testing.T) {
+	storage := newTestS3Storage(t)
+	ctx := context.Background()
+	localPath, data, checksum := writeTestArchive(t, t.TempDir(), 256<<10)
+
+	if err := storage.Upload(ctx, "backup.tar.gz", localPath, checksum); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	assertStored(t, storage, "backup.tar.gz", data)
+
+	if err := storage.Delete(ctx, "backup.tar.gz"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := storage.Open(ctx, "backup.tar.gz"); !errors.Is(err, ErrBackupNotFound) {
+		t.Fatalf("Open after Delete error = %v, want ErrBackupNotFound", err)
+	}
+}
+
+func TestS3StorageResume(t *testing.T) {
+	storage := newTestS3Storage(t)
+	ctx := context.Background()
+	localPath, data, checksum := writeTestArchive(t, t.TempDir(), s3PartSize+1<<20)
+	key := storage.key("backup.tar.gz")
+
+	// an interrupted upload sent the first part only
+	uploadID, err := storage.core.NewMultipartUpload(ctx, storage.bucket, key, minio.PutObjectOptions{
+		UserMetadata: map[string]string{s3ChecksumMetadata: checksum},
+	})
+	if err != nil {
+		t.Fatalf("NewMultipartUpload: %v", err)
+	}
+	if _, err := storage.core.PutObjectPart(ctx, storage.bucket, key, uploadID, 1, bytes.NewReader(data[:s3PartSize]), s3PartSize, minio.PutObjectPartOptions{}); err != nil {
+		t.Fatalf("PutObjectPart: %v", err)
+	}
+
+	if err := storage.Upload(ctx, "backup.tar.gz", localPath, checksum); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	assertStored(t, storage, "backup.tar.gz", data)
+
+	// the interrupted upload was completed rather than left behind
+	if id, _, err := storage.resumeUpload(ctx, key); err != nil || id != "" {
+		t.Fatalf("unfinished upload = %q, %v, want none", id, err)
+	}
+}
+
+func TestS3StorageChecksumMismatch(t *testing.T) {
+	storage := newTestS3Storage(t)
+	ctx := context.Background()
+	localPath, data, checksum := writeTestArchive(t, t.TempDir(), 256<<10)
+
+	wrong, err := readChecksum(bytes.NewReader([]byte("another archive")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Upload(ctx, "backup.tar.gz", localPath, wrong); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Upload error = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := storage.Open(ctx, "backup.tar.gz"); !errors.Is(err, ErrBackupNotFound) {
+		t.Fatalf("Open error = %v, want the mismatching archive removed", err)
+	}
+
+	// a stored archive claiming the checksum without matching it is uploaded again
+	corrupted := bytes.Repeat([]byte{0}, len(data))
+	if _, err := storage.core.PutObject(ctx, storage.bucket, storage.key("backup.tar.gz"), bytes.NewReader(corrupted), int64(len(corrupted)), "", "", minio.PutObjectOptions{
+		UserMetadata: map[string]string{s3ChecksumMetadata: checksum},
+	}); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	if err := storage.Upload(ctx, "backup.tar.gz", localPath, checksum); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	assertStored(t, storage, "backup.tar.gz", data)
+}
@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const sftpDialTimeout = 30 * time.Second
+
+// Keeps the archives in a directory of an SFTP server
+type sftpStorage struct {
+	addr   string
+	config *ssh.ClientConfig
+	dir    string
+}
+
+func newSftpStorage(config internal.SftpBackupStorage) (*sftpStorage, error) {
+	if config.Host == "" || config.User == "" {
+		return nil, errors.New("sftp backup storage needs a host and a user")
+	}
+	if config.HostKey == "" {
+		return nil, errors.New("sftp backup storage needs the host key of the server")
+	}
+
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(config.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sftp host key: %v", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if config.PrivateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(config.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sftp private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auth = append(auth, ssh.Password(config.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftp backup storage needs a password or a private key")
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = 22
+	}
+
+	return &sftpStorage{
+		addr: net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		config: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            auth,
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+			Timeout:         sftpDialTimeout,
+		},
+		dir: config.Dir,
+	}, nil
+}
+
+func (s *sftpStorage) Driver() string {
+	return internal.BackupStorageSftp
+}
+
+func (s *sftpStorage) path(name string) string {
+	return path.Join(s.dir, name)
+}
+
+// Sftp client with the ssh connection it runs on
+type sftpSession struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+func (s *sftpSession) Close() error {
+	err := s.Client.Close()
+	s.conn.Close()
+	return err
+}
+
+func (s *sftpStorage) connect() (*sftpSession, error) {
+	conn, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to sftp server: %v", err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %v", err)
+	}
+	return &sftpSession{Client: client, conn: conn}, nil
+}
+
+// Appends the rest of the archive to the partial copy left by an interrupted upload,
+// reads it back to verify it, and moves it in place
+func (s *sftpStorage) Upload(c context.Context, name string, localPath string, checksum string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// closing the connection interrupts a transfer in progress
+	stop := context.AfterFunc(c, func() { client.Close() })
+	defer stop()
+
+	if s.dir != "" {
+		if err := client.MkdirAll(s.dir); err != nil {
+			return fmt.Errorf("failed to create backup storage directory: %v", err)
+		}
+	}
+
+	dst := s.path(name)
+	partPath := dst + ".part"
+
+	part, err := client.OpenFile(partPath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return fmt.Errorf("failed to create stored archive: %v", err)
+	}
+	defer part.Close()
+
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to read stored archive: %v", err)
+	}
+
+	src, err := openAt(localPath, offset)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, err := io.Copy(part, src); err != nil {
+		return fmt.Errorf("failed to upload backup archive: %v", err)
+	}
+	if err := part.Close(); err != nil {
+		return fmt.Errorf("failed to upload backup archive: %v", err)
+	}
+
+	if err := s.verify(client, partPath, checksum); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			// the partial copy is broken, the next attempt starts over
+			client.Remove(partPath)
+		}
+		return err
+	}
+
+	if err := client.PosixRename(partPath, dst); err != nil {
+		return fmt.Errorf("failed to save stored archive: %v", err)
+	}
+	return nil
+}
+
+func (s *sftpStorage) verify(client *sftpSession, path string, checksum string) error {
+	f, err := client.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open stored archive: %v", err)
+	}
+	defer f.Close()
+
+	sum, err := readChecksum(f)
+	if err != nil {
+		return fmt.Errorf("failed to read stored archive: %v", err)
+	}
+	if sum != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (s *sftpStorage) Open(c context.Context, name string) (io.ReadCloser, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(s.path(name))
+	if err != nil {
+		client.Close()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrBackupNotFound
+		}
+		return nil, fmt.Errorf("failed to open backup archive: %v", err)
+	}
+
+	return &sftpFile{File: f, session: client}, nil
+}
+
+func (s *sftpStorage) Delete(c context.Context, name string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete backup archive: %v", err)
+	}
+	client.Remove(s.path(name) + ".part")
+	return nil
+}
+
+// Remote file that ends its sftp session when closed
+type sftpFile struct {
+	*sftp.File
+	session *sftpSession
+}
+
+func (f *sftpFile) Close() error {
+	err := f.File.Close()
+	f.session.Close()
+	return err
+}
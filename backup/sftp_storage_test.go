@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Starts an SFTP server logging in the user with the password, and gets its config with the directory it serves
+func newSftpServer(t *testing.T, user string, password string) (internal.SftpBackupStorage, string) {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, given []byte) (*ssh.Permissions, error) {
+			if conn.User() != user || string(given) != password {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftp(conn, config)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	dir := t.TempDir()
+	return internal.SftpBackupStorage{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		User:     user,
+		Password: password,
+		HostKey:  string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+		Dir:      dir,
+	}, dir
+}
+
+func serveSftp(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				// the payload of a subsystem request is the length prefixed name
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				server.Serve()
+				return
+			}
+		}()
+	}
+}
+
+func TestSftpStorage(t *testing.T) {
+	config, dir := newSftpServer(t, "gshub", "secret")
+
+	storage, err := newSftpStorage(config)
+	if err != nil {
+		t.Fatalf("newSftpStorage: %v", err)
+	}
+	testPartialUploads(t, storage, dir)
+}
+
+func TestSftpStorageRejectsUnknownServer(t *testing.T) {
+	config, _ := newSftpServer(t, "gshub", "secret")
+	other, _ := newSftpServer(t, "gshub", "secret")
+	config.HostKey = other.HostKey
+
+	storage, err := newSftpStorage(config)
+	if err != nil {
+		t.Fatalf("newSftpStorage: %v", err)
+	}
+
+	localPath, _, checksum := writeTestArchive(t, t.TempDir(), 1024)
+	if err := storage.Upload(context.Background(), "backup.tar.gz", localPath, checksum); err == nil {
+		t.Fatal("Upload succeeded with a server whose host key does not match")
+	}
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+// Storage keeps the backup archives once they are made
+type Storage interface {
+	// Name of the driver, as chosen by the main api
+	Driver() string
+	// Copies the local archive to the storage under the name. An interrupted upload continues where it stopped,
+	// and the stored archive is verified against the checksum before it is kept.
+	Upload(c context.Context, name string, localPath string, checksum string) error
+	Open(c context.Context, name string) (io.ReadCloser, error)
+	// Deletes the archive, an archive that does not exist is not an error
+	Delete(c context.Context, name string) error
+}
+
+// Creates the storage chosen in the startup payload, archives are staged in the staging directory until they are uploaded
+func NewStorage(config internal.BackupStorage, stagingDir string) (Storage, error) {
+	switch config.Driver {
+	case "", internal.BackupStorageLocal:
+		dir := config.Path
+		if dir == "" {
+			dir = stagingDir
+		}
+		return newLocalStorage(dir), nil
+	case internal.BackupStorageS3:
+		return newS3Storage(config.S3)
+	case internal.BackupStorageSftp:
+		return newSftpStorage(config.Sftp)
+	default:
+		return nil, fmt.Errorf("unknown backup storage driver: %s", config.Driver)
+	}
+}
+
+// Gets the hex encoded sha256 of what is read
+func readChecksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Opens the local file at the offset an interrupted upload stopped at
+func openAt(localPath string, offset int64) (*os.File, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup archive: %v", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read backup archive: %v", err)
+	}
+	return f, nil
+}
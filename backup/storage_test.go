@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+// Writes an archive of random bytes to the directory and gets its content and checksum
+func writeTestArchive(t *testing.T, dir string, size int) (string, []byte, string) {
+	t.Helper()
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+
+	localPath := filepath.Join(dir, "backup.tar.gz")
+	if err := os.WriteFile(localPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := readChecksum(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return localPath, data, checksum
+}
+
+// Fails unless the storage holds the archive under the name
+func assertStored(t *testing.T, storage Storage, name string, want []byte) {
+	t.Helper()
+
+	r, err := storage.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read stored archive: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("stored archive has %d bytes, want the %d bytes uploaded", len(got), len(want))
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     internal.BackupStorage
+		wantDriver string
+	}{
+		{"default", internal.BackupStorage{}, internal.BackupStorageLocal},
+		{"local", internal.BackupStorage{Driver: internal.BackupStorageLocal, Path: "/srv/backups"}, internal.BackupStorageLocal},
+		{"s3", internal.BackupStorage{Driver: internal.BackupStorageS3, S3: internal.S3BackupStorage{Endpoint: "localhost:9000", Bucket: "backups"}}, internal.BackupStorageS3},
+		{"unknown driver", internal.BackupStorage{Driver: "ftp"}, ""},
+		{"s3 without bucket", internal.BackupStorage{Driver: internal.BackupStorageS3, S3: internal.S3BackupStorage{Endpoint: "localhost:9000"}}, ""},
+		{"sftp without host key", internal.BackupStorage{Driver: internal.BackupStorageSftp, Sftp: internal.SftpBackupStorage{Host: "backup", User: "gshub", Password: "secret"}}, ""},
+		{"sftp with a bad host key", internal.BackupStorage{Driver: internal.BackupStorageSftp, Sftp: internal.SftpBackupStorage{Host: "backup", User: "gshub", Password: "secret", HostKey: "not a key"}}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorage(test.config, t.TempDir())
+			if test.wantDriver == "" {
+				if err == nil {
+					t.Fatalf("NewStorage succeeded with driver %s", storage.Driver())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewStorage: %v", err)
+			}
+			if storage.Driver() != test.wantDriver {
+				t.Fatalf("driver = %s, want %s", storage.Driver(), test.wantDriver)
+			}
+		})
+	}
+}
+
+// Runs the upload cases of the drivers keeping a partial copy of the archive next to it in the directory
+func testPartialUploads(t *testing.T, storage Storage, dir string) {
+	ctx := context.Background()
+	localPath, data, checksum := writeTestArchive(t, t.TempDir(), 256<<10)
+	partPath := filepath.Join(dir, "resumed.tar.gz.part")
+
+	t.Run("upload", func(t *testing.T) {
+		if err := storage.Upload(ctx, "uploaded.tar.gz", localPath, checksum); err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		assertStored(t, storage, "uploaded.tar.gz", data)
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		// an interrupted upload left the first half
+		if err := os.WriteFile(partPath, data[:len(data)/2], 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := storage.Upload(ctx, "resumed.tar.gz", localPath, checksum); err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		assertStored(t, storage, "resumed.tar.gz", data)
+		if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+			t.Fatalf("the partial copy was kept: %v", err)
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		// the partial copy was corrupted on the way
+		corrupted := bytes.Repeat([]byte{0}, len(data)/2)
+		if err := os.WriteFile(filepath.Join(dir, "corrupted.tar.gz.part"), corrupted, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := storage.Upload(ctx, "corrupted.tar.gz", localPath, checksum); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("Upload error = %v, want ErrChecksumMismatch", err)
+		}
+		if _, err := storage.Open(ctx, "corrupted.tar.gz"); !errors.Is(err, ErrBackupNotFound) {
+			t.Fatalf("Open error = %v, want ErrBackupNotFound", err)
+		}
+
+		// the next attempt starts over
+		if err := storage.Upload(ctx, "corrupted.tar.gz", localPath, checksum); err != nil {
+			t.Fatalf("Upload after a mismatch: %v", err)
+		}
+		assertStored(t, storage, "corrupted.tar.gz", data)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := storage.Delete(ctx, "uploaded.tar.gz"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := storage.Open(ctx, "uploaded.tar.gz"); !errors.Is(err, ErrBackupNotFound) {
+			t.Fatalf("Open after Delete error = %v, want ErrBackupNotFound", err)
+		}
+		if err := storage.Delete(ctx, "uploaded.tar.gz"); err != nil {
+			t.Fatalf("Delete of a missing archive: %v", err)
+		}
+	})
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	testPartialUploads(t, newLocalStorage(dir), dir)
+}
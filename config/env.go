@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +22,21 @@ type Environment struct {
 	// ServiceMinimumMemoryRequired int
 	CyclesUrl string
 	// OwnerID                      uint
+	LowCycleAlerts string
+	InternalApiKey string
+	// Seconds without players before the service is stopped, 0 disables it
+	IdleTimeout int
+	// Shut the instance down after the service has been stopped for the idle timeout
+	IdleShutdown bool
+	// Comma separated urls that receive console events
+	EventWebhooks      string
+	EventWebhookSecret string
+	// Seconds between scheduled backups, 0 disables them
+	BackupInterval int
+	// Number of backups to keep, 0 keeps all of them
+	BackupMaxCount int
+	// Seconds after which backups are deleted, 0 keeps them forever
+	BackupMaxAge int
 }
 
 func LoadEnv() {
@@ -64,6 +80,8 @@ func LoadEnv() {
 	// 	log.Fatalf("invalid OWNER_ID env value: %s", ownerIDStr)
 	// }
 
+	idleShutdown := os.Getenv("IDLE_SHUTDOWN") == "true"
+
 	Env = Environment{
 		AppEnv:     os.Getenv("APP_ENV"),
 		DSN:        os.Getenv("DSN"),
@@ -76,5 +94,28 @@ func LoadEnv() {
 		// ServiceMinimumMemoryRequired: serviceMinimumMemoryRequired,
 		CyclesUrl: os.Getenv("CYCLES_URL"),
 		// OwnerID:                      uint(ownerID),
+		LowCycleAlerts:     os.Getenv("LOW_CYCLE_ALERTS"),
+		InternalApiKey:     os.Getenv("INTERNAL_API_KEY"),
+		IdleTimeout:        getIntEnv("IDLE_TIMEOUT"),
+		IdleShutdown:       idleShutdown,
+		EventWebhooks:      os.Getenv("EVENT_WEBHOOKS"),
+		EventWebhookSecret: os.Getenv("EVENT_WEBHOOK_SECRET"),
+		BackupInterval:     getIntEnv("BACKUP_INTERVAL"),
+		BackupMaxCount:     getIntEnv("BACKUP_MAX_COUNT"),
+		BackupMaxAge:       getIntEnv("BACKUP_MAX_AGE"),
+	}
+}
+
+// Converts an optional env value to int, it is 0 when not set
+func getIntEnv(key string) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid %s env value: %s", key, value)
 	}
+	return result
 }
@@ -0,0 +1,94 @@
+package cycles
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Seconds of time left at which the owner and the players are warned by default
+var DefaultAlertThresholds = []uint{3600, 600, 60}
+
+// Alerter reports when the time left drops to one of the configured thresholds in seconds.
+// Every threshold fires once, and is armed again if the time left goes back above it.
+type Alerter struct {
+	mu         sync.Mutex
+	thresholds []uint
+	fired      map[uint]bool
+}
+
+func NewAlerter(thresholds []uint) *Alerter {
+	sorted := append([]uint(nil), thresholds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	return &Alerter{
+		thresholds: sorted,
+		fired:      make(map[uint]bool, len(sorted)),
+	}
+}
+
+// Returns the thresholds reached since the last check, highest first.
+// The time left depends on the burn rate, see Pricing.TimeLeft.
+func (a *Alerter) Check(timeLeft time.Duration) []uint {
+	seconds := uint(timeLeft / time.Second)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var reached []uint
+	for _, threshold := range a.thresholds {
+		if seconds > threshold {
+			delete(a.fired, threshold)
+			continue
+		}
+
+		if !a.fired[threshold] {
+			a.fired[threshold] = true
+			reached = append(reached, threshold)
+		}
+	}
+
+	return reached
+}
+
+// Parses a comma separated list of thresholds in seconds of time left, e.g. "3600,600,60"
+func ParseAlertThresholds(value string) ([]uint, error) {
+	if strings.TrimSpace(value) == "" {
+		return DefaultAlertThresholds, nil
+	}
+
+	var thresholds []uint
+	for _, part := range strings.Split(value, ",") {
+		threshold, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alert threshold: %s", part)
+		}
+		thresholds = append(thresholds, uint(threshold))
+	}
+
+	return thresholds, nil
+}
+
+// Formats the time left, e.g. "1 hour" or "10 minutes"
+func FormatRemaining(timeLeft time.Duration) string {
+	seconds := uint(timeLeft.Seconds())
+
+	switch {
+	case seconds >= 3600:
+		return plural(seconds/3600, "hour")
+	case seconds >= 60:
+		return plural(seconds/60, "minute")
+	default:
+		return plural(seconds, "second")
+	}
+}
+
+func plural(n uint, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
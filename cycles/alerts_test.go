@@ -0,0 +1,99 @@
+package cycles
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseAlertThresholds(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []uint
+		wantErr bool
+	}{
+		{"", DefaultAlertThresholds, false},
+		{"  ", DefaultAlertThresholds, false},
+		{"600", []uint{600}, false},
+		{"3600, 600 ,60", []uint{3600, 600, 60}, false},
+		{"600,abc", nil, true},
+		{"-1", nil, true},
+		{"600,,60", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseAlertThresholds(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseAlertThresholds(%q) error = %v, wantErr %v", test.value, err, test.wantErr)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("ParseAlertThresholds(%q) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAlerterFiresOnce(t *testing.T) {
+	alerter := NewAlerter([]uint{60, 600})
+
+	if got := alerter.Check(700 * time.Second); len(got) != 0 {
+		t.Fatalf("Check(700s) = %v, want none", got)
+	}
+	if got := alerter.Check(50 * time.Second); !slices.Equal(got, []uint{600, 60}) {
+		t.Fatalf("Check(50s) = %v, want [600 60]", got)
+	}
+	if got := alerter.Check(40 * time.Second); len(got) != 0 {
+		t.Fatalf("Check(40s) = %v, want none", got)
+	}
+	// a top-up arms the thresholds again
+	alerter.Check(700 * time.Second)
+	if got := alerter.Check(500 * time.Second); !slices.Equal(got, []uint{600}) {
+		t.Fatalf("Check(500s) = %v, want [600]", got)
+	}
+}
+
+func TestAlerterUsesTimeLeft(t *testing.T) {
+	// two cycles a second while running, half a cycle while stopped
+	pricing := Pricing{RunningRate: 2, StoppedRate: 0.5}
+
+	tests := []struct {
+		remaining uint
+		running   bool
+		want      []uint
+	}{
+		// 1000 cycles last 500 seconds while running, although more than 600 cycles remain
+		{1000, true, []uint{600}},
+		{1300, true, nil},
+		// 250 cycles last 500 seconds while stopped, although fewer than 600 cycles remain
+		{250, false, []uint{600}},
+		{350, false, nil},
+		{100, true, []uint{600, 60}},
+	}
+
+	for _, test := range tests {
+		alerter := NewAlerter([]uint{600, 60})
+		timeLeft := pricing.TimeLeft(test.remaining, test.running)
+		if got := alerter.Check(timeLeft); !slices.Equal(got, test.want) {
+			t.Errorf("Check(TimeLeft(%d, %v)) = %v, want %v", test.remaining, test.running, got, test.want)
+		}
+	}
+}
+
+func TestFormatRemaining(t *testing.T) {
+	tests := []struct {
+		timeLeft time.Duration
+		want     string
+	}{
+		{2 * time.Hour, "2 hours"},
+		{time.Hour, "1 hour"},
+		{10 * time.Minute, "10 minutes"},
+		{time.Second, "1 second"},
+	}
+
+	for _, test := range tests {
+		if got := FormatRemaining(test.timeLeft); got != test.want {
+			t.Errorf("FormatRemaining(%v) = %q, want %q", test.timeLeft, got, test.want)
+		}
+	}
+}
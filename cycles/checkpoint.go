@@ -0,0 +1,138 @@
+package cycles
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const DefaultCheckpointInterval = 15 * time.Second
+
+// Checkpointer periodically writes the burned cycles of the current boot session
+// to the local database so they survive a crash or a power loss
+type Checkpointer struct {
+	db        *gorm.DB
+	meter     *Meter
+	sessionID string
+	interval  time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewCheckpointer(db *gorm.DB, meter *Meter, sessionID string, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		db:        db,
+		meter:     meter,
+		sessionID: sessionID,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Starts writing a checkpoint every interval in the background
+func (c *Checkpointer) Start() {
+	go c.run()
+}
+
+// Stops the background checkpoints and waits for the one in progress to finish
+func (c *Checkpointer) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *Checkpointer) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.Save(); err != nil {
+				log.Printf("Failed to checkpoint burned cycles: %v", err)
+			}
+		}
+	}
+}
+
+// Upserts the checkpoint of the current session with the burned cycles so far
+func (c *Checkpointer) Save() error {
+	burned, seconds := c.meter.Usage()
+
+	checkpoint := internal.CycleCheckpoint{
+		SessionID: c.sessionID,
+		Amount:    burned,
+		Seconds:   seconds,
+	}
+
+	return c.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "session_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"amount", "seconds", "updated_at"}),
+	}).Create(&checkpoint).Error
+}
+
+// Removes the checkpoint of the current session.
+// It must run in the same transaction that reports or persists the burned cycles.
+func (c *Checkpointer) Clear(tx *gorm.DB) error {
+	return tx.Where("session_id = ?", c.sessionID).Delete(&internal.CycleCheckpoint{}).Error
+}
+
+// Turns checkpoints left behind by sessions that did not exit cleanly into failed burned cycles.
+// The session ID is kept on the failed burned cycle so a checkpoint is never recovered twice.
+func RecoverCheckpoints(db *gorm.DB) (uint, error) {
+	var recovered uint
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var checkpoints []internal.CycleCheckpoint
+		if err := tx.Find(&checkpoints).Error; err != nil {
+			return fmt.Errorf("failed to get checkpoints: %v", err)
+		}
+
+		for _, checkpoint := range checkpoints {
+			if checkpoint.Amount > 0 || checkpoint.Seconds > 0 {
+				failed := internal.FailedBurnedCycle{
+					SessionID:      checkpoint.SessionID,
+					IdempotencyKey: ReportKey(checkpoint.SessionID),
+					Amount:         checkpoint.Amount,
+					Seconds:        checkpoint.Seconds,
+				}
+
+				result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&failed)
+				if result.Error != nil {
+					return fmt.Errorf("failed to save checkpoint %s: %v", checkpoint.SessionID, result.Error)
+				}
+
+				if result.RowsAffected > 0 {
+					recovered += checkpoint.Amount
+				}
+			}
+
+			if err := tx.Delete(&checkpoint).Error; err != nil {
+				return fmt.Errorf("failed to delete checkpoint %s: %v", checkpoint.SessionID, err)
+			}
+		}
+
+		return nil
+	})
+
+	return recovered, err
+}
+
+// Generates a random identifier for the current boot session
+func NewSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
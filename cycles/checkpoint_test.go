@@ -0,0 +1,185 @@
+package cycles
+
+import (
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"gorm.io/gorm"
+)
+
+func newCheckpointDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db := newTestDB(t)
+	if err := db.AutoMigrate(&internal.CycleCheckpoint{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// Checkpointer of a session that burned the cycles at one cycle per second
+func newBurnedCheckpointer(db *gorm.DB, sessionID string, burned int) *Checkpointer {
+	meter := NewMeter(1000)
+	for i := 0; i < burned; i++ {
+		meter.Burn(1)
+	}
+	return NewCheckpointer(db, meter, sessionID, DefaultCheckpointInterval)
+}
+
+func countCheckpoints(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(&internal.CycleCheckpoint{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestSaveUpdatesCheckpoint(t *testing.T) {
+	db := newCheckpointDB(t)
+	checkpointer := newBurnedCheckpointer(db, "session", 5)
+
+	if err := checkpointer.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	checkpointer.meter.Burn(1)
+	if err := checkpointer.Save(); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	var checkpoints []internal.CycleCheckpoint
+	db.Find(&checkpoints)
+	if len(checkpoints) != 1 || checkpoints[0].Amount != 6 || checkpoints[0].Seconds != 6 {
+		t.Fatalf("checkpoints = %+v, want one with 6 cycles over 6 seconds", checkpoints)
+	}
+}
+
+func TestRecoverCheckpoints(t *testing.T) {
+	db := newCheckpointDB(t)
+	if err := newBurnedCheckpointer(db, "crashed", 42).Save(); err != nil {
+		t.Fatal(err)
+	}
+	// a session that crashed before burning anything has nothing to report
+	if err := newBurnedCheckpointer(db, "idle", 0).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := RecoverCheckpoints(db)
+	if err != nil {
+		t.Fatalf("RecoverCheckpoints: %v", err)
+	}
+	if recovered != 42 {
+		t.Fatalf("recovered %d cycles, want 42", recovered)
+	}
+
+	pending, err := PendingReports(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := internal.FailedBurnedCycle{SessionID: "crashed", IdempotencyKey: ReportKey("crashed"), Amount: 42, Seconds: 42}
+	if len(pending) != 1 || pending[0].SessionID != want.SessionID || pending[0].IdempotencyKey != want.IdempotencyKey ||
+		pending[0].Amount != want.Amount || pending[0].Seconds != want.Seconds {
+		t.Fatalf("pending = %+v, want one report like %+v", pending, want)
+	}
+	if count := countCheckpoints(t, db); count != 0 {
+		t.Fatalf("%d checkpoints left, want every checkpoint removed", count)
+	}
+}
+
+func TestRecoverCheckpointsTwice(t *testing.T) {
+	db := newCheckpointDB(t)
+	checkpointer := newBurnedCheckpointer(db, "crashed", 10)
+
+	steps := []struct {
+		name          string
+		saveFirst     bool
+		wantRecovered uint
+	}{
+		{"first recovery", true, 10},
+		{"nothing left", false, 0},
+		// the checkpoint of an already recovered session shows up again, its report is not duplicated
+		{"recovered session", true, 0},
+	}
+
+	for _, step := range steps {
+		if step.saveFirst {
+			if err := checkpointer.Save(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		recovered, err := RecoverCheckpoints(db)
+		if err != nil {
+			t.Fatalf("%s: RecoverCheckpoints: %v", step.name, err)
+		}
+		if recovered != step.wantRecovered {
+			t.Fatalf("%s: recovered %d cycles, want %d", step.name, recovered, step.wantRecovered)
+		}
+
+		pending, err := PendingReports(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 || pending[0].Amount != 10 {
+			t.Fatalf("%s: pending = %+v, want exactly one report of 10 cycles", step.name, pending)
+		}
+		if count := countCheckpoints(t, db); count != 0 {
+			t.Fatalf("%s: %d checkpoints left, want none", step.name, count)
+		}
+	}
+}
+
+func TestClearAfterReport(t *testing.T) {
+	db := newCheckpointDB(t)
+	current := newBurnedCheckpointer(db, "current", 7)
+	other := newBurnedCheckpointer(db, "other", 3)
+	for _, checkpointer := range []*Checkpointer{current, other} {
+		if err := checkpointer.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the burned cycles were reported, only the checkpoint of the current session goes away
+	if err := current.Clear(db); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	var checkpoints []internal.CycleCheckpoint
+	db.Find(&checkpoints)
+	if len(checkpoints) != 1 || checkpoints[0].SessionID != "other" {
+		t.Fatalf("checkpoints = %+v, want only the one of the other session", checkpoints)
+	}
+
+	// nothing of the reported session is recovered on the next startup
+	recovered, err := RecoverCheckpoints(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered != 3 {
+		t.Fatalf("recovered %d cycles, want only the 3 of the other session", recovered)
+	}
+}
+
+func TestClearRollsBackWithReport(t *testing.T) {
+	db := newCheckpointDB(t)
+	checkpointer := newBurnedCheckpointer(db, "current", 7)
+	if err := checkpointer.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a report that could not be persisted keeps the checkpoint, so the cycles are recovered later
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkpointer.Clear(tx); err != nil {
+			return err
+		}
+		return gorm.ErrInvalidTransaction
+	})
+	if err == nil {
+		t.Fatal("transaction succeeded, want it rolled back")
+	}
+
+	if count := countCheckpoints(t, db); count != 1 {
+		t.Fatalf("%d checkpoints left, want the checkpoint kept", count)
+	}
+}
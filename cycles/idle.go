@@ -0,0 +1,208 @@
+package cycles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+const idleCheckInterval = 30 * time.Second
+
+const (
+	IdleActionStopService      = "stopService"
+	IdleActionShutdownInstance = "shutdownInstance"
+)
+
+// GameServer is the part of the service controller the idle monitor relies on
+type GameServer interface {
+	GetService(c context.Context) (service.Container, error)
+	PlayerCount(c context.Context) (int, error)
+	GracefulStop(c context.Context) error
+}
+
+// PlayerTracker counts the players seen joining and leaving in the console,
+// for games whose server can not be asked how many players are online
+type PlayerTracker interface {
+	OnlinePlayers() (int, bool)
+}
+
+// IdleMonitor stops the game when nobody plays on it, and optionally shuts the instance down,
+// so the owner does not pay for an empty server
+type IdleMonitor struct {
+	server   GameServer
+	players  PlayerTracker
+	client   *internal.ApiClient
+	timeout  time.Duration
+	shutdown bool
+
+	mu        sync.Mutex
+	idleSince time.Time
+	stop      chan struct{}
+	done      chan struct{}
+	// closed when the instance should shut down because it has been idle
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	// whether it was logged that the players can not be counted, so it is not logged on every check
+	uncounted bool
+}
+
+func NewIdleMonitor(server GameServer, players PlayerTracker, client *internal.ApiClient, timeout time.Duration, shutdown bool) *IdleMonitor {
+	return &IdleMonitor{
+		server:     server,
+		players:    players,
+		client:     client,
+		timeout:    timeout,
+		shutdown:   shutdown,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+// Closed when the instance has been idle long enough to be shut down
+func (m *IdleMonitor) ShutdownRequested() <-chan struct{} {
+	return m.shutdownCh
+}
+
+// Starts checking for idleness in the background, a zero timeout disables the monitor
+func (m *IdleMonitor) Start() {
+	if m.timeout <= 0 {
+		close(m.done)
+		return
+	}
+
+	go m.run()
+}
+
+// Restarts the idle period, called when players join or leave or the game becomes ready
+func (m *IdleMonitor) Activity() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.idleSince = time.Now()
+}
+
+// Stops checking for idleness and waits for the check in progress to finish
+func (m *IdleMonitor) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+func (m *IdleMonitor) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	m.Activity()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *IdleMonitor) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), idleCheckInterval)
+	defer cancel()
+
+	container, err := m.server.GetService(ctx)
+	if errors.Is(err, service.ErrContainerNotFound) {
+		// there is no server to watch yet, it gets a full idle period once it is created
+		m.Activity()
+		return
+	}
+	if err != nil {
+		log.Printf("Idle check failed: %v", err)
+		return
+	}
+	running := container.Running
+
+	if running {
+		players, err := m.playerCount(ctx)
+		if err != nil {
+			// without a player count the server can not be considered idle
+			if !m.uncounted {
+				m.uncounted = true
+				log.Printf("Idle stop is disabled until the players can be counted: %v", err)
+			}
+			m.Activity()
+			return
+		}
+		m.uncounted = false
+
+		if players > 0 {
+			m.Activity()
+			return
+		}
+	}
+
+	m.mu.Lock()
+	idleFor := time.Since(m.idleSince)
+	m.mu.Unlock()
+
+	if idleFor < m.timeout {
+		return
+	}
+
+	if running {
+		log.Printf("No players for %s, stopping the service", idleFor.Round(time.Second))
+
+		if err := m.stopService(); err != nil {
+			log.Printf("Failed to stop idle service: %v", err)
+			return
+		}
+
+		m.report(IdleActionStopService, idleFor)
+
+		// the instance gets a full idle period with the service stopped before it is shut down
+		m.Activity()
+		return
+	}
+
+	if m.shutdown {
+		log.Printf("Service stopped for %s, shutting down the instance", idleFor.Round(time.Second))
+		m.report(IdleActionShutdownInstance, idleFor)
+		m.shutdownOnce.Do(func() { close(m.shutdownCh) })
+	}
+}
+
+// Asks the game how many players are online, games that can not be asked are counted from their console
+func (m *IdleMonitor) playerCount(c context.Context) (int, error) {
+	players, err := m.server.PlayerCount(c)
+	if !errors.Is(err, service.ErrNotSupported) || m.players == nil {
+		return players, err
+	}
+
+	// the console is only followed from when the game started, the count is known once it is ready
+	players, ok := m.players.OnlinePlayers()
+	if !ok {
+		return 0, fmt.Errorf("%w: the players are counted from the console once the game is ready", err)
+	}
+	return players, nil
+}
+
+func (m *IdleMonitor) stopService() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	return m.server.GracefulStop(ctx)
+}
+
+func (m *IdleMonitor) report(action string, idleFor time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := m.client.PostIdleAction(ctx, action, uint(idleFor.Seconds())); err != nil {
+		log.Printf("Failed to report idle action: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package cycles
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+type fakeGameServer struct {
+	container service.Container
+	err       error
+	players   int
+	// returned by PlayerCount, like games that can not be asked
+	countErr error
+	stopped  bool
+}
+
+func (s *fakeGameServer) GetService(c context.Context) (service.Container, error) {
+	return s.container, s.err
+}
+
+func (s *fakeGameServer) PlayerCount(c context.Context) (int, error) {
+	return s.players, s.countErr
+}
+
+func (s *fakeGameServer) GracefulStop(c context.Context) error {
+	s.stopped = true
+	return nil
+}
+
+func TestIdleMonitorWithoutContainer(t *testing.T) {
+	server := &fakeGameServer{err: service.ErrContainerNotFound}
+	monitor := NewIdleMonitor(server, nil, nil, time.Minute, true)
+	monitor.idleSince = time.Now().Add(-time.Hour)
+
+	monitor.check()
+
+	select {
+	case <-monitor.ShutdownRequested():
+		t.Fatal("instance without a container was shut down")
+	default:
+	}
+	if time.Since(monitor.idleSince) > time.Minute {
+		t.Fatal("idle period was not restarted")
+	}
+}
+
+func TestIdleMonitorKeepsServerWithPlayers(t *testing.T) {
+	server := &fakeGameServer{container: service.Container{Running: true}, players: 2}
+	monitor := NewIdleMonitor(server, nil, nil, time.Minute, true)
+	monitor.idleSince = time.Now().Add(-time.Hour)
+
+	monitor.check()
+
+	if server.stopped {
+		t.Fatal("server with players was stopped")
+	}
+}
+
+type fakePlayerTracker struct {
+	players int
+	ready   bool
+}
+
+func (t fakePlayerTracker) OnlinePlayers() (int, bool) {
+	return t.players, t.ready
+}
+
+func TestIdleMonitorCountsPlayersFromConsole(t *testing.T) {
+	tests := []struct {
+		name        string
+		countErr    error
+		tracker     PlayerTracker
+		wantStopped bool
+	}{
+		{"players online", service.ErrNotSupported, fakePlayerTracker{players: 1, ready: true}, false},
+		{"nobody online", service.ErrNotSupported, fakePlayerTracker{players: 0, ready: true}, true},
+		{"game not ready", service.ErrNotSupported, fakePlayerTracker{players: 0, ready: false}, false},
+		{"no tracker", service.ErrNotSupported, nil, false},
+		// the console is only asked when the game can not be
+		{"count failed", errors.New("rcon unreachable"), fakePlayerTracker{players: 0, ready: true}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the idle action is reported to the main api
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer api.Close()
+			t.Setenv("INTERNAL_API_URL", api.URL)
+
+			server := &fakeGameServer{container: service.Container{Running: true}, countErr: test.countErr}
+			monitor := NewIdleMonitor(server, test.tracker, internal.NewClient(), time.Minute, false)
+			monitor.idleSince = time.Now().Add(-time.Hour)
+
+			monitor.check()
+
+			if server.stopped != test.wantStopped {
+				t.Fatalf("stopped = %v, want %v", server.stopped, test.wantStopped)
+			}
+			if !test.wantStopped && time.Since(monitor.idleSince) > time.Minute {
+				t.Fatal("idle period was not restarted")
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package cycles
+
+import (
+	"math"
+	"sync"
+)
+
+// Meter keeps track of the cycles burned against the budget of this instance.
+// It is safe for concurrent use by the burn loop, the checkpointer and the handlers.
+type Meter struct {
+	mu     sync.RWMutex
+	budget uint
+	burned uint
+	// thousandths of a cycle carried over when the rate is not a whole number
+	carry uint
+	// wall-clock seconds the meter has been burning for
+	seconds uint
+	// rate of the last burn
+	rate   float64
+	topUps map[string]bool
+}
+
+func NewMeter(budget uint) *Meter {
+	return &Meter{
+		budget: budget,
+		topUps: make(map[string]bool),
+	}
+}
+
+// Extends the budget with purchased cycles.
+// A top-up is applied only once no matter how many times it is delivered.
+func (m *Meter) TopUp(ID string, amount uint) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.topUps[ID] {
+		return false
+	}
+
+	m.topUps[ID] = true
+	m.budget += amount
+	return true
+}
+
+// Burns one second worth of cycles at the given rate and returns the total burned so far
+func (m *Meter) Burn(rate float64) uint {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rate = rate
+	m.carry += uint(math.Round(rate * 1000))
+	m.burned += m.carry / 1000
+	m.carry %= 1000
+	m.seconds++
+
+	return m.burned
+}
+
+// Wall-clock seconds the cycles were burned over
+func (m *Meter) Seconds() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.seconds
+}
+
+// Cycles burned per second at the moment
+func (m *Meter) Rate() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.rate
+}
+
+// Returns the burned cycles together with the seconds they were burned over
+func (m *Meter) Usage() (uint, uint) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.burned, m.seconds
+}
+
+func (m *Meter) Burned() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.burned
+}
+
+func (m *Meter) Budget() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.budget
+}
+
+func (m *Meter) Remaining() uint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.burned >= m.budget {
+		return 0
+	}
+	return m.budget - m.burned
+}
+
+func (m *Meter) Exhausted() bool {
+	return m.Remaining() == 0
+}
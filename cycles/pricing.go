@@ -0,0 +1,52 @@
+package cycles
+
+import (
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+// Returned by TimeLeft when nothing is burned, the cycles last until the state changes
+const NoTimeLimit time.Duration = -1
+
+// Pricing tells how many cycles are burned per second depending on the state of the service
+type Pricing struct {
+	RunningRate float64
+	StoppedRate float64
+}
+
+// Builds the pricing of the instance plan.
+// Without a running rate a cycle costs one second, without a stopped rate the running rate applies.
+func NewPricing(plan internal.CyclePricing) Pricing {
+	pricing := Pricing{
+		RunningRate: 1,
+	}
+
+	if plan.RunningRate != nil && *plan.RunningRate >= 0 {
+		pricing.RunningRate = *plan.RunningRate
+	}
+
+	pricing.StoppedRate = pricing.RunningRate
+	if plan.StoppedRate != nil && *plan.StoppedRate >= 0 {
+		pricing.StoppedRate = *plan.StoppedRate
+	}
+
+	return pricing
+}
+
+// Returns the cycles burned per second
+func (p Pricing) Rate(running bool) float64 {
+	if running {
+		return p.RunningRate
+	}
+	return p.StoppedRate
+}
+
+// Returns how long the remaining cycles last at the current rate, NoTimeLimit when nothing is burned
+func (p Pricing) TimeLeft(remaining uint, running bool) time.Duration {
+	rate := p.Rate(running)
+	if rate <= 0 {
+		return NoTimeLimit
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second))
+}
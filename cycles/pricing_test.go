@@ -0,0 +1,57 @@
+package cycles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+func rate(r float64) *float64 {
+	return &r
+}
+
+func TestNewPricing(t *testing.T) {
+	tests := []struct {
+		name string
+		plan internal.CyclePricing
+		want Pricing
+	}{
+		{"default", internal.CyclePricing{}, Pricing{RunningRate: 1, StoppedRate: 1}},
+		{"running only", internal.CyclePricing{RunningRate: rate(2)}, Pricing{RunningRate: 2, StoppedRate: 2}},
+		{"both", internal.CyclePricing{RunningRate: rate(2), StoppedRate: rate(0.5)}, Pricing{RunningRate: 2, StoppedRate: 0.5}},
+		{"free when stopped", internal.CyclePricing{StoppedRate: rate(0)}, Pricing{RunningRate: 1, StoppedRate: 0}},
+		{"negative ignored", internal.CyclePricing{RunningRate: rate(-1), StoppedRate: rate(-1)}, Pricing{RunningRate: 1, StoppedRate: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NewPricing(test.plan); got != test.want {
+				t.Fatalf("NewPricing = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTimeLeft(t *testing.T) {
+	pricing := Pricing{RunningRate: 2, StoppedRate: 0}
+
+	tests := []struct {
+		name      string
+		remaining uint
+		running   bool
+		want      time.Duration
+	}{
+		{"running", 120, true, time.Minute},
+		{"none left", 0, true, 0},
+		{"nothing burned", 120, false, NoTimeLimit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := pricing.TimeLeft(test.remaining, test.running); got != test.want {
+				t.Fatalf("TimeLeft = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package cycles
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/config"
+	"github.com/mooncorn/gshub-server-api/internal"
+	"gorm.io/gorm"
+)
+
+// Returns the idempotency key of the cycles burned during a boot session.
+// Shutdown reports, checkpoint recoveries and startup resends of the same session share it,
+// so the main api can tell when the same cycles are reported more than once.
+func ReportKey(sessionID string) string {
+	return fmt.Sprintf("%s-%s", config.Env.InstanceId, sessionID)
+}
+
+// Returns the failed burned cycles that the main api has not acknowledged yet
+func PendingReports(db *gorm.DB) ([]internal.FailedBurnedCycle, error) {
+	var pending []internal.FailedBurnedCycle
+	if err := db.Where("acknowledged_at IS NULL").Find(&pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to get pending reports: %v", err)
+	}
+
+	// rows saved before idempotency keys existed get a stable key derived from their id
+	for i := range pending {
+		if pending[i].IdempotencyKey != "" {
+			continue
+		}
+
+		pending[i].IdempotencyKey = fmt.Sprintf("%s-legacy-%d", config.Env.InstanceId, pending[i].ID)
+		if err := db.Model(&pending[i]).Update("idempotency_key", pending[i].IdempotencyKey).Error; err != nil {
+			return nil, fmt.Errorf("failed to assign idempotency key: %v", err)
+		}
+	}
+
+	return pending, nil
+}
+
+// Marks the failed burned cycles confirmed by the main api as acknowledged
+func AcknowledgeReports(db *gorm.DB, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return db.Model(&internal.FailedBurnedCycle{}).
+		Where("idempotency_key IN ? AND acknowledged_at IS NULL", keys).
+		Update("acknowledged_at", time.Now()).Error
+}
+
+// Deletes the failed burned cycles the main api acknowledged longer than the retention ago
+func PruneAcknowledgedReports(db *gorm.DB, retention time.Duration) error {
+	err := db.Unscoped().
+		Where("acknowledged_at < ?", time.Now().Add(-retention)).
+		Delete(&internal.FailedBurnedCycle{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to prune acknowledged reports: %v", err)
+	}
+	return nil
+}
+
+func ToCycleReports(failed []internal.FailedBurnedCycle) []internal.CycleReport {
+	reports := make([]internal.CycleReport, len(failed))
+	for i, f := range failed {
+		reports[i] = internal.CycleReport{
+			IdempotencyKey: f.IdempotencyKey,
+			Amount:         f.Amount,
+			Seconds:        f.Seconds,
+		}
+	}
+	return reports
+}
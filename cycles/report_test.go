@@ -0,0 +1,78 @@
+package cycles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&internal.FailedBurnedCycle{}, &internal.AppliedTopUp{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+func TestPendingReportsSkipsAcknowledged(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&internal.FailedBurnedCycle{SessionID: "a", IdempotencyKey: "key-a", Amount: 10})
+	db.Create(&internal.FailedBurnedCycle{SessionID: "b", IdempotencyKey: "key-b", Amount: 20})
+
+	if err := AcknowledgeReports(db, []string{"key-a"}); err != nil {
+		t.Fatalf("AcknowledgeReports: %v", err)
+	}
+
+	pending, err := PendingReports(db)
+	if err != nil {
+		t.Fatalf("PendingReports: %v", err)
+	}
+	if len(pending) != 1 || pending[0].IdempotencyKey != "key-b" {
+		t.Fatalf("pending = %+v, want only key-b", pending)
+	}
+}
+
+func TestPendingReportsKeysLegacyRows(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&internal.FailedBurnedCycle{SessionID: "a", IdempotencyKey: "", Amount: 10})
+
+	first, err := PendingReports(db)
+	if err != nil {
+		t.Fatalf("PendingReports: %v", err)
+	}
+	second, err := PendingReports(db)
+	if err != nil {
+		t.Fatalf("PendingReports: %v", err)
+	}
+
+	if first[0].IdempotencyKey == "" || first[0].IdempotencyKey != second[0].IdempotencyKey {
+		t.Fatalf("legacy key is not stable: %q then %q", first[0].IdempotencyKey, second[0].IdempotencyKey)
+	}
+}
+
+func TestPruneAcknowledgedReports(t *testing.T) {
+	db := newTestDB(t)
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now()
+	db.Create(&internal.FailedBurnedCycle{SessionID: "old", IdempotencyKey: "old", AcknowledgedAt: &old})
+	db.Create(&internal.FailedBurnedCycle{SessionID: "recent", IdempotencyKey: "recent", AcknowledgedAt: &recent})
+	db.Create(&internal.FailedBurnedCycle{SessionID: "pending", IdempotencyKey: "pending"})
+
+	if err := PruneAcknowledgedReports(db, 24*time.Hour); err != nil {
+		t.Fatalf("PruneAcknowledgedReports: %v", err)
+	}
+
+	var keys []string
+	db.Unscoped().Model(&internal.FailedBurnedCycle{}).Order("id").Pluck("idempotency_key", &keys)
+	if len(keys) != 2 || keys[0] != "recent" || keys[1] != "pending" {
+		t.Fatalf("kept %v, want [recent pending]", keys)
+	}
+}
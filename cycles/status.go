@@ -0,0 +1,66 @@
+package cycles
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"gorm.io/gorm"
+)
+
+type Status struct {
+	Budget    uint    `json:"budget"`
+	Burned    uint    `json:"burned"`
+	Remaining uint    `json:"remaining"`
+	Seconds   uint    `json:"seconds"`
+	Rate      float64 `json:"rate"`
+	// When the instance shuts down if the rate does not change, nil while nothing is burned
+	ProjectedShutdownAt *time.Time `json:"projectedShutdownAt"`
+	Backlog             Backlog    `json:"backlog"`
+}
+
+// Failed burned cycles that still have to be reported to the main api
+type Backlog struct {
+	Reports uint `json:"reports"`
+	Amount  uint `json:"amount"`
+}
+
+func GetStatus(meter *Meter, db *gorm.DB) (Status, error) {
+	meter.mu.RLock()
+	status := Status{
+		Budget:  meter.budget,
+		Burned:  meter.burned,
+		Seconds: meter.seconds,
+		Rate:    meter.rate,
+	}
+	meter.mu.RUnlock()
+
+	if status.Burned < status.Budget {
+		status.Remaining = status.Budget - status.Burned
+	}
+
+	if status.Rate > 0 {
+		projected := time.Now().Add(time.Duration(float64(status.Remaining) / status.Rate * float64(time.Second)))
+		status.ProjectedShutdownAt = &projected
+	}
+
+	backlog, err := GetBacklog(db)
+	if err != nil {
+		return Status{}, err
+	}
+	status.Backlog = backlog
+
+	return status, nil
+}
+
+func GetBacklog(db *gorm.DB) (Backlog, error) {
+	var backlog Backlog
+	err := db.Model(&internal.FailedBurnedCycle{}).
+		Select("COUNT(*) AS reports, COALESCE(SUM(amount), 0) AS amount").
+		Where("acknowledged_at IS NULL").
+		Scan(&backlog).Error
+	if err != nil {
+		return Backlog{}, fmt.Errorf("failed to get failed burned cycles backlog: %v", err)
+	}
+	return backlog, nil
+}
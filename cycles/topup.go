@@ -0,0 +1,102 @@
+package cycles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const DefaultTopUpPollInterval = time.Minute
+
+// TopUpPoller periodically asks the main api for cycles purchased after startup
+type TopUpPoller struct {
+	db       *gorm.DB
+	client   *internal.ApiClient
+	meter    *Meter
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewTopUpPoller(db *gorm.DB, client *internal.ApiClient, meter *Meter, interval time.Duration) *TopUpPoller {
+	return &TopUpPoller{
+		db:       db,
+		client:   client,
+		meter:    meter,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Starts polling for top-ups in the background
+func (p *TopUpPoller) Start() {
+	go p.run()
+}
+
+// Stops polling and waits for the poll in progress to finish
+func (p *TopUpPoller) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+func (p *TopUpPoller) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Poll(); err != nil {
+				log.Printf("Failed to poll cycle top-ups: %v", err)
+			}
+		}
+	}
+}
+
+// Applies every top-up the main api knows about that was not applied yet
+func (p *TopUpPoller) Poll() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+	defer cancel()
+
+	topUps, err := p.client.GetTopUps(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get top-ups: %v", err)
+	}
+
+	for _, topUp := range topUps {
+		if _, err := ApplyTopUp(p.db, p.meter, topUp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Extends the budget of the meter with the top-up unless it was already applied.
+// Applied top-ups are recorded in the database so they are not credited again after a restart.
+func ApplyTopUp(db *gorm.DB, meter *Meter, topUp internal.TopUp) (bool, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&internal.AppliedTopUp{
+		ID:     topUp.ID,
+		Cycles: topUp.Cycles,
+	})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to save top-up %s: %v", topUp.ID, result.Error)
+	}
+
+	if result.RowsAffected == 0 || !meter.TopUp(topUp.ID, topUp.Cycles) {
+		return false, nil
+	}
+
+	log.Printf("Applied top-up %s: %d cycles, new budget %d", topUp.ID, topUp.Cycles, meter.Budget())
+	return true, nil
+}
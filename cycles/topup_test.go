@@ -0,0 +1,46 @@
+package cycles
+
+import (
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+func TestApplyTopUpOnce(t *testing.T) {
+	db := newTestDB(t)
+	meter := NewMeter(100)
+	topUp := internal.TopUp{ID: "topup-1", Cycles: 50}
+
+	applied, err := ApplyTopUp(db, meter, topUp)
+	if err != nil || !applied {
+		t.Fatalf("first ApplyTopUp = %v, %v, want true", applied, err)
+	}
+
+	applied, err = ApplyTopUp(db, meter, topUp)
+	if err != nil || applied {
+		t.Fatalf("second ApplyTopUp = %v, %v, want false", applied, err)
+	}
+
+	if budget := meter.Budget(); budget != 150 {
+		t.Fatalf("budget = %d, want 150", budget)
+	}
+}
+
+func TestApplyTopUpSurvivesRestart(t *testing.T) {
+	db := newTestDB(t)
+	topUp := internal.TopUp{ID: "topup-1", Cycles: 50}
+
+	if _, err := ApplyTopUp(db, NewMeter(100), topUp); err != nil {
+		t.Fatalf("ApplyTopUp: %v", err)
+	}
+
+	// a new meter is what the instance starts with after a restart
+	meter := NewMeter(150)
+	applied, err := ApplyTopUp(db, meter, topUp)
+	if err != nil || applied {
+		t.Fatalf("ApplyTopUp after restart = %v, %v, want false", applied, err)
+	}
+	if budget := meter.Budget(); budget != 150 {
+		t.Fatalf("budget = %d, want 150", budget)
+	}
+}
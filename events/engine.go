@@ -0,0 +1,321 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+const (
+	followRetryDelay = 5 * time.Second
+	// How many events a slow subscriber can fall behind before events are dropped for it
+	subscriberBufferSize = 64
+)
+
+// Event is something that happened in the game, recognized from a console line
+type Event struct {
+	Type   service.EventType `json:"type"`
+	Time   time.Time         `json:"time"`
+	Player string            `json:"player,omitempty"`
+	Line   string            `json:"line"`
+	// connection the line is about, only used to tell who left
+	session string
+}
+
+// Status is what the engine knows about the game from the events so far
+type Status struct {
+	Ready   bool     `json:"ready"`
+	Players []string `json:"players"`
+}
+
+// Source is the part of the service controller the engine follows
+type Source interface {
+	FollowConsole(c context.Context, tail string, since string) (<-chan service.LogLine, <-chan error)
+	LogPatterns(c context.Context) ([]service.LogPattern, error)
+}
+
+// Engine follows the console of the service and turns lines matching the log patterns of its strategy into events.
+// Events are passed to the handlers and the subscribers as they happen.
+type Engine struct {
+	mu          sync.Mutex
+	source      Source
+	handlers    []func(Event)
+	subscribers map[chan Event]struct{}
+
+	ready   bool
+	players map[string]struct{}
+	// sessions of connections whose player is not known yet, oldest first
+	pending []string
+	// players by the session of their connection
+	sessions map[string]string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewEngine(source Source) *Engine {
+	return &Engine{
+		source:      source,
+		subscribers: make(map[chan Event]struct{}),
+		players:     make(map[string]struct{}),
+		sessions:    make(map[string]string),
+		done:        make(chan struct{}),
+	}
+}
+
+// Registers a handler called for every new event, handlers must be added before Start and must not block
+func (e *Engine) OnEvent(handler func(Event)) {
+	e.handlers = append(e.handlers, handler)
+}
+
+// Starts following the console in the background
+func (e *Engine) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+
+	go e.run(ctx)
+}
+
+// Stops following the console and closes the channels of all subscribers
+func (e *Engine) Stop() {
+	if e.cancel == nil {
+		return
+	}
+
+	e.cancel()
+	<-e.done
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subscribers {
+		close(ch)
+		delete(e.subscribers, ch)
+	}
+}
+
+// Returns a channel receiving new events and a function that ends the subscription
+func (e *Engine) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBufferSize)
+
+	e.mu.Lock()
+	e.subscribers[ch] = struct{}{}
+	e.mu.Unlock()
+
+	unsubscribe := func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if _, ok := e.subscribers[ch]; ok {
+			close(ch)
+			delete(e.subscribers, ch)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// Gets whether the game is ready and who is online
+func (e *Engine) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	players := make([]string, 0, len(e.players))
+	for player := range e.players {
+		players = append(players, player)
+	}
+	sort.Strings(players)
+
+	return Status{Ready: e.ready, Players: players}
+}
+
+// Gets how many players are online, known only once the game logged that it is ready
+func (e *Engine) OnlinePlayers() (int, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.players), e.ready
+}
+
+func (e *Engine) run(ctx context.Context) {
+	defer close(e.done)
+
+	started := time.Now()
+	var last time.Time
+	lastErr := ""
+
+	for {
+		if err := e.follow(ctx, started, &last); err != nil && err.Error() != lastErr {
+			// the container is missing or stopped most of the time, only log when something changes
+			log.Printf("Console events are waiting for the service: %v", err)
+			lastErr = err.Error()
+		} else if err == nil {
+			lastErr = ""
+		}
+
+		// whatever was known about the game is stale once its console ends
+		e.reset()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(followRetryDelay):
+		}
+	}
+}
+
+// Matches the console lines until the log stream ends.
+// Lines logged before the engine started only rebuild the status, they are not published again.
+func (e *Engine) follow(ctx context.Context, started time.Time, last *time.Time) error {
+	patterns, err := e.source.LogPatterns(ctx)
+	if err != nil {
+		return err
+	}
+
+	since := ""
+	if !last.IsZero() {
+		since = fmt.Sprintf("%d.%09d", last.Unix(), last.Nanosecond())
+	}
+
+	lines, errs := e.source.FollowConsole(ctx, "all", since)
+	for line := range lines {
+		// the since filter of docker is not precise, skip what was matched already
+		if !line.Timestamp.After(*last) {
+			continue
+		}
+		*last = line.Timestamp
+
+		event, ok := match(patterns, line)
+		if !ok {
+			continue
+		}
+
+		event, ok = e.correlate(event)
+		if !ok {
+			continue
+		}
+
+		e.apply(event)
+		if event.Time.After(started) {
+			e.publish(event)
+		}
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Gets the event of the first pattern matching the line
+func match(patterns []service.LogPattern, line service.LogLine) (Event, bool) {
+	for _, pattern := range patterns {
+		groups := pattern.Regex.FindStringSubmatch(line.Text)
+		if groups == nil {
+			continue
+		}
+
+		event := Event{Type: pattern.Event, Time: line.Timestamp, Line: line.Text}
+		if i := pattern.Regex.SubexpIndex("player"); i > 0 {
+			event.Player = groups[i]
+		}
+		if i := pattern.Regex.SubexpIndex("session"); i > 0 {
+			event.session = groups[i]
+		}
+		return event, true
+	}
+	return Event{}, false
+}
+
+// Ties players to the sessions of their connections, for games that log players leaving by session only.
+// Returns false for events that must not be published.
+func (e *Engine) correlate(event Event) (Event, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch event.Type {
+	case service.EventPlayerConnected:
+		e.pending = append(e.pending, event.session)
+		return event, false
+	case service.EventPlayerJoined:
+		for _, player := range e.sessions {
+			// the character is logged again when the player respawns
+			if player == event.Player {
+				return event, false
+			}
+		}
+		if len(e.pending) == 0 {
+			break
+		}
+		e.sessions[e.pending[0]] = event.Player
+		e.pending = e.pending[1:]
+	case service.EventPlayerLeft:
+		if event.session == "" {
+			break
+		}
+		player, ok := e.sessions[event.session]
+		delete(e.sessions, event.session)
+		// the connection may close before a character was chosen
+		e.pending = slices.DeleteFunc(e.pending, func(session string) bool { return session == event.session })
+		if !ok {
+			return event, false
+		}
+		event.Player = player
+	}
+
+	return event, true
+}
+
+func (e *Engine) apply(event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch event.Type {
+	case service.EventServerReady:
+		e.ready = true
+	case service.EventCrashed:
+		e.ready = false
+		e.players = make(map[string]struct{})
+		e.pending = nil
+		e.sessions = make(map[string]string)
+	case service.EventPlayerJoined:
+		e.players[event.Player] = struct{}{}
+	case service.EventPlayerLeft:
+		delete(e.players, event.Player)
+	}
+}
+
+func (e *Engine) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.ready = false
+	e.players = make(map[string]struct{})
+	e.pending = nil
+	e.sessions = make(map[string]string)
+}
+
+func (e *Engine) publish(event Event) {
+	for _, handler := range e.handlers {
+		handler(event)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropped %s event for a slow subscriber", event.Type)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+// fakeSource replays console lines once
+type fakeSource struct {
+	lines    []string
+	patterns []service.LogPattern
+}
+
+func (s *fakeSource) FollowConsole(c context.Context, tail string, since string) (<-chan service.LogLine, <-chan error) {
+	lines := make(chan service.LogLine, len(s.lines))
+	start := time.Now()
+	for i, text := range s.lines {
+		lines <- service.LogLine{Stream: service.StreamStdout, Timestamp: start.Add(time.Duration(i+1) * time.Millisecond), Text: text}
+	}
+	close(lines)
+	return lines, make(chan error, 1)
+}
+
+func (s *fakeSource) LogPatterns(c context.Context) ([]service.LogPattern, error) {
+	return s.patterns, nil
+}
+
+// Follows the lines and returns the published events
+func replay(t *testing.T, game service.ServiceStrategy, lines ...string) (*Engine, []Event) {
+	t.Helper()
+
+	engine := NewEngine(&fakeSource{lines: lines, patterns: game.LogPatterns()})
+	var events []Event
+	engine.OnEvent(func(event Event) { events = append(events, event) })
+
+	var last time.Time
+	if err := engine.follow(context.Background(), time.Time{}, &last); err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+	return engine, events
+}
+
+func TestValheimPlayersLeave(t *testing.T) {
+	engine, events := replay(t, service.NewValheimServiceStrategy(nil),
+		"02/05/2024 10:00:00: Got connection SteamID 76561198000000001",
+		"02/05/2024 10:00:05: Got character ZDOID from Ragnar : 1234:1",
+		"02/05/2024 10:01:00: Got connection SteamID 76561198000000002",
+		"02/05/2024 10:01:05: Got character ZDOID from Lagertha : 5678:1",
+		"02/05/2024 10:02:00: Got character ZDOID from Ragnar : 0:0",
+		"02/05/2024 10:02:10: Got character ZDOID from Ragnar : 1240:1",
+		"02/05/2024 10:03:00: Closing socket 76561198000000001",
+		"02/05/2024 10:03:30: Got connection SteamID 76561198000000003",
+		"02/05/2024 10:03:40: Closing socket 76561198000000003",
+	)
+
+	want := []struct {
+		kind   service.EventType
+		player string
+	}{
+		{service.EventPlayerJoined, "Ragnar"},
+		{service.EventPlayerJoined, "Lagertha"},
+		{service.EventPlayerLeft, "Ragnar"},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(events), events, len(want))
+	}
+	for i, event := range events {
+		if event.Type != want[i].kind || event.Player != want[i].player {
+			t.Fatalf("event %d = %s %s, want %s %s", i, event.Type, event.Player, want[i].kind, want[i].player)
+		}
+	}
+
+	if players := engine.Status().Players; len(players) != 1 || players[0] != "Lagertha" {
+		t.Fatalf("players = %v, want [Lagertha]", players)
+	}
+}
+
+func TestValheimOnlinePlayers(t *testing.T) {
+	engine, _ := replay(t, service.NewValheimServiceStrategy(nil),
+		"02/05/2024 10:00:00: Got connection SteamID 76561198000000001",
+		"02/05/2024 10:00:05: Got character ZDOID from Ragnar : 1234:1",
+	)
+	// players who joined before the game logged it is ready are not counted on
+	if players, ok := engine.OnlinePlayers(); ok {
+		t.Fatalf("OnlinePlayers = %d, %v before the game was ready, want not known", players, ok)
+	}
+
+	engine, _ = replay(t, service.NewValheimServiceStrategy(nil),
+		"02/05/2024 09:59:00: Game server connected",
+		"02/05/2024 10:00:00: Got connection SteamID 76561198000000001",
+		"02/05/2024 10:00:05: Got character ZDOID from Ragnar : 1234:1",
+		"02/05/2024 10:01:00: Got connection SteamID 76561198000000002",
+		"02/05/2024 10:01:05: Got character ZDOID from Lagertha : 5678:1",
+		"02/05/2024 10:03:00: Closing socket 76561198000000001",
+	)
+	if players, ok := engine.OnlinePlayers(); players != 1 || !ok {
+		t.Fatalf("OnlinePlayers = %d, %v, want 1, true", players, ok)
+	}
+}
+
+func TestMinecraftPlayersLeave(t *testing.T) {
+	engine, events := replay(t, service.NewMinecraftServiceStrategy(nil),
+		`[12:00:00] [Server thread/INFO]: Done (3.2s)! For help, type "help"`,
+		"[12:00:01] [Server thread/INFO]: Steve joined the game",
+		"[12:00:02] [Server thread/INFO]: Alex joined the game",
+		"[12:00:03] [Server thread/INFO]: Steve left the game",
+	)
+
+	if len(events) != 4 {
+		t.Fatalf("got %d events %+v, want 4", len(events), events)
+	}
+
+	status := engine.Status()
+	if !status.Ready || len(status.Players) != 1 || status.Players[0] != "Alex" {
+		t.Fatalf("status = %+v, want ready with Alex online", status)
+	}
+}
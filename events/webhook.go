@@ -0,0 +1,169 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+const (
+	// Header carrying the hex encoded HMAC-SHA256 of the body, keyed with the webhook secret
+	SignatureHeader = "X-Signature"
+
+	webhookQueueSize = 64
+	webhookTimeout   = time.Minute
+)
+
+// WebhookSender posts events to the configured urls in the background, retrying failed deliveries.
+// Every url has its own queue, so a slow or failing url does not hold back the others.
+type WebhookSender struct {
+	secret     string
+	instanceId string
+	httpClient *http.Client
+	policy     internal.RetryPolicy
+
+	queues map[string]chan Event
+	wg     sync.WaitGroup
+
+	// cancels the deliveries in progress when the sender stops before they are done
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewWebhookSender(urls []string, secret string, instanceId string) *WebhookSender {
+	queues := make(map[string]chan Event, len(urls))
+	for _, url := range urls {
+		queues[url] = make(chan Event, webhookQueueSize)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookSender{
+		ctx:        ctx,
+		cancel:     cancel,
+		secret:     secret,
+		instanceId: instanceId,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		policy:     internal.DefaultRetryPolicy,
+		queues:     queues,
+	}
+}
+
+// Parses a comma separated list of webhook urls
+func ParseWebhookUrls(value string) []string {
+	var urls []string
+	for _, url := range strings.Split(value, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// Starts delivering events in the background
+func (w *WebhookSender) Start() {
+	for url, queue := range w.queues {
+		w.wg.Add(1)
+		go w.run(url, queue)
+	}
+}
+
+// Stops accepting events and waits for the queued ones to be delivered until the context is done,
+// what is left then is dropped so the shutdown is not held back by a webhook that does not answer
+func (w *WebhookSender) Stop(c context.Context) {
+	for _, queue := range w.queues {
+		close(queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-c.Done():
+		w.cancel()
+		<-done
+	}
+	w.cancel()
+}
+
+// Queues the event for delivery to every url, it is dropped for a url whose queue is full so the engine never waits
+func (w *WebhookSender) Send(event Event) {
+	for url, queue := range w.queues {
+		select {
+		case queue <- event:
+		default:
+			log.Printf("Webhook queue of %s is full, dropped %s event", url, event.Type)
+		}
+	}
+}
+
+func (w *WebhookSender) run(url string, queue <-chan Event) {
+	defer w.wg.Done()
+
+	for event := range queue {
+		if w.ctx.Err() != nil {
+			log.Printf("Webhook sender stopped, dropped %s event for %s", event.Type, url)
+			continue
+		}
+		if err := w.deliver(url, event); err != nil {
+			log.Printf("Failed to deliver %s event to webhook %s: %v", event.Type, url, err)
+		}
+	}
+}
+
+func (w *WebhookSender) deliver(url string, event Event) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"instanceId": w.instanceId,
+		"event":      event,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, webhookTimeout)
+	defer cancel()
+
+	return w.policy.Do(ctx, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if w.secret != "" {
+			req.Header.Set(SignatureHeader, sign(w.secret, body))
+		}
+
+		resp, err := w.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return &internal.StatusError{StatusCode: resp.StatusCode, Body: responseBody}
+		}
+		return nil
+	})
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+func TestWebhookSlowUrlDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+
+	delivered := make(chan string, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if signature := r.Header.Get(SignatureHeader); signature != sign("secret", body) {
+			t.Errorf("signature = %q, want %q", signature, sign("secret", body))
+		}
+		delivered <- string(body)
+	}))
+	defer fast.Close()
+
+	sender := NewWebhookSender([]string{slow.URL, fast.URL}, "secret", "instance")
+	sender.Start()
+
+	sender.Send(Event{Type: service.EventPlayerJoined, Player: "Steve"})
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered while another url was slow")
+	}
+
+	close(release)
+	sender.Stop(context.Background())
+}
+
+func TestWebhookStopDoesNotWaitForHangingUrl(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan struct{}, 1)
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	sender := NewWebhookSender([]string{hanging.URL}, "", "instance")
+	sender.Start()
+
+	for i := 0; i < 3; i++ {
+		sender.Send(Event{Type: service.EventPlayerJoined, Player: "Steve"})
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not sent")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	sender.Stop(ctx)
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("Stop took %s while the url did not answer", elapsed)
+	}
+}
+
+func TestParseWebhookUrls(t *testing.T) {
+	urls := ParseWebhookUrls(" https://a.example/hook, ,https://b.example/hook ")
+	if len(urls) != 2 || urls[0] != "https://a.example/hook" || urls[1] != "https://b.example/hook" {
+		t.Fatalf("ParseWebhookUrls = %q", urls)
+	}
+}
@@ -0,0 +1,542 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mooncorn/gshub-server-api/service"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Largest file that can be read and written as text
+	DefaultMaxTextSize = 1024 * 1024
+	// Largest file that can be uploaded
+	DefaultMaxUploadSize = 256 * 1024 * 1024
+
+	// Bytes looked at to tell text files from binary ones
+	sniffSize = 8 * 1024
+)
+
+var (
+	ErrInvalidPath  = errors.New("path is outside of the server volumes")
+	ErrFileNotFound = errors.New("file not found")
+	ErrFileExists   = errors.New("file already exists")
+	ErrFileTooLarge = errors.New("file is too large")
+	// Returned when a binary file is read or written as text
+	ErrBinaryFile   = errors.New("file is not a text file")
+	ErrIsDirectory  = errors.New("path is a directory")
+	ErrNotDirectory = errors.New("path is not a directory")
+	// Returned when the directory a volume is mounted on would be renamed or deleted
+	ErrVolumeRoot = errors.New("the root of a volume can not be changed")
+)
+
+// VolumeSource is the part of the service controller the file manager relies on
+type VolumeSource interface {
+	GetVolumes(c context.Context) ([]service.VolumeBinding, error)
+}
+
+// File or directory in a volume
+type Entry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Whether the file can be read and written as text
+	Editable bool `json:"editable"`
+}
+
+// Manager gives access to the files in the volumes of the service, and nothing else on the host
+type Manager struct {
+	source        VolumeSource
+	maxTextSize   int64
+	maxUploadSize int64
+}
+
+func NewManager(source VolumeSource, maxTextSize int64, maxUploadSize int64) *Manager {
+	return &Manager{
+		source:        source,
+		maxTextSize:   maxTextSize,
+		maxUploadSize: maxUploadSize,
+	}
+}
+
+func (m *Manager) MaxUploadSize() int64 {
+	return m.maxUploadSize
+}
+
+func (m *Manager) resolve(c context.Context, p string) (location, error) {
+	volumes, err := m.source.GetVolumes(c)
+	if err != nil {
+		return location{}, err
+	}
+	return resolve(volumes, p)
+}
+
+// Lists the directory, the root lists the volumes
+func (m *Manager) List(c context.Context, p string) ([]Entry, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if clean == "/" {
+		return m.listVolumes(c)
+	}
+
+	loc, err := m.resolve(c, clean)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := openRoot(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	dir, err := root.open(loc.rel, unix.O_RDONLY|unix.O_DIRECTORY)
+	if err != nil {
+		return nil, fileError(err, "read directory")
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %v", err)
+	}
+
+	entries := make([]Entry, 0, len(names))
+	for _, name := range names {
+		child := loc.child(name)
+		info, err := root.lstat(child.rel)
+		if err != nil {
+			// removed while it was listed
+			continue
+		}
+		entries = append(entries, m.entry(root, child, info))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+func (m *Manager) listVolumes(c context.Context) ([]Entry, error) {
+	volumes, err := m.source.GetVolumes(c)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(volumes))
+	for _, volume := range volumes {
+		entry := Entry{Name: path.Clean("/" + volume.Container), Path: path.Clean("/" + volume.Container), Dir: true}
+		if info, err := os.Stat(volume.Host); err == nil {
+			entry.ModTime = info.ModTime()
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (m *Manager) entry(root *volumeRoot, loc location, info fs.FileInfo) Entry {
+	entry := Entry{
+		Name:    info.Name(),
+		Path:    loc.virtual,
+		Dir:     info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if info.Mode().IsRegular() && info.Size() <= m.maxTextSize {
+		if f, err := root.open(loc.rel, unix.O_RDONLY|unix.O_NOFOLLOW); err == nil {
+			entry.Editable = sniffText(f)
+			f.Close()
+		}
+	}
+	return entry
+}
+
+// Reads the file as text
+func (m *Manager) ReadText(c context.Context, p string) (string, error) {
+	f, info, err := m.Open(c, p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if info.Size() > m.maxTextSize {
+		return "", ErrFileTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, m.maxTextSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	if int64(len(data)) > m.maxTextSize {
+		return "", ErrFileTooLarge
+	}
+	if !isText(data) {
+		return "", ErrBinaryFile
+	}
+	return string(data), nil
+}
+
+// Replaces the content of the file with the text, creating it when it does not exist
+func (m *Manager) WriteText(c context.Context, p string, content string) error {
+	if int64(len(content)) > m.maxTextSize {
+		return ErrFileTooLarge
+	}
+	if !isText([]byte(content)) {
+		return ErrBinaryFile
+	}
+
+	loc, err := m.resolve(c, p)
+	if err != nil {
+		return err
+	}
+
+	root, err := openRoot(loc)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	return m.write(root, loc, bytes.NewReader([]byte(content)), m.maxTextSize)
+}
+
+// Saves the uploaded file in the directory, replacing a file with the same name
+func (m *Manager) Upload(c context.Context, dir string, name string, r io.Reader) (Entry, error) {
+	if !validName(name) {
+		return Entry{}, ErrInvalidPath
+	}
+
+	cleanDir, err := cleanPath(dir)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	loc, err := m.resolve(c, path.Join(cleanDir, name))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	root, err := openRoot(loc)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer root.Close()
+
+	if err := m.write(root, loc, r, m.maxUploadSize); err != nil {
+		return Entry{}, err
+	}
+
+	info, err := root.lstat(loc.rel)
+	if err != nil {
+		return Entry{}, fileError(err, "read file")
+	}
+	return m.entry(root, loc, info), nil
+}
+
+// Creates the directory and its missing parents, they get the owner of the directory they are created in
+func (m *Manager) MakeDir(c context.Context, p string) error {
+	loc, err := m.resolve(c, p)
+	if err != nil {
+		return err
+	}
+
+	root, err := openRoot(loc)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	// every directory is created in the one opened before it, a link swapped in on the way can not lead out of the volume
+	dir, err := root.open("", unix.O_RDONLY|unix.O_DIRECTORY)
+	if err != nil {
+		return fileError(err, "read directory")
+	}
+	defer func() { dir.Close() }()
+
+	if loc.isRoot() {
+		return nil
+	}
+
+	for _, name := range strings.Split(loc.rel, "/") {
+		next, err := root.openAt(int(dir.Fd()), name, unix.O_RDONLY|unix.O_DIRECTORY)
+		if errors.Is(err, unix.ENOENT) {
+			if err := unix.Mkdirat(int(dir.Fd()), name, 0o755); err != nil && !errors.Is(err, unix.EEXIST) {
+				return fmt.Errorf("failed to create directory: %v", err)
+			}
+			// the game runs as its own user, the files must stay its own; this only works as root
+			if info, err := dir.Stat(); err == nil {
+				if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+					unix.Fchownat(int(dir.Fd()), name, int(stat.Uid), int(stat.Gid), unix.AT_SYMLINK_NOFOLLOW)
+				}
+			}
+			next, err = root.openAt(int(dir.Fd()), name, unix.O_RDONLY|unix.O_DIRECTORY)
+		}
+		if err != nil {
+			return fileError(err, "create directory")
+		}
+
+		dir.Close()
+		dir = next
+	}
+	return nil
+}
+
+// Opens the file for a download
+func (m *Manager) Open(c context.Context, p string) (*os.File, fs.FileInfo, error) {
+	loc, err := m.resolve(c, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root, err := openRoot(loc)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer root.Close()
+
+	f, err := root.open(loc.rel, unix.O_RDONLY)
+	if err != nil {
+		return nil, nil, fileError(err, "open file")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, nil, ErrIsDirectory
+	}
+	return f, info, nil
+}
+
+// Moves the file or directory, the destination must not exist
+func (m *Manager) Rename(c context.Context, from string, to string) error {
+	src, err := m.resolve(c, from)
+	if err != nil {
+		return err
+	}
+	dst, err := m.resolve(c, to)
+	if err != nil {
+		return err
+	}
+
+	if src.isRoot() || dst.isRoot() {
+		return ErrVolumeRoot
+	}
+
+	srcRoot, err := openRoot(src)
+	if err != nil {
+		return err
+	}
+	defer srcRoot.Close()
+
+	dstRoot, err := openRoot(dst)
+	if err != nil {
+		return err
+	}
+	defer dstRoot.Close()
+
+	srcDir, srcName, err := srcRoot.openDir(src)
+	if err != nil {
+		return fileError(err, "read directory")
+	}
+	defer srcDir.Close()
+
+	if err := exists(srcDir, srcName); err != nil {
+		return fileError(err, "read file")
+	}
+
+	dstDir, dstName, err := dstRoot.openDir(dst)
+	if err != nil {
+		return fileError(err, "read directory")
+	}
+	defer dstDir.Close()
+
+	err = unix.Renameat2(int(srcDir.Fd()), srcName, int(dstDir.Fd()), dstName, unix.RENAME_NOREPLACE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		// the file system can not refuse to replace the destination by itself
+		if err := exists(dstDir, dstName); err == nil {
+			return ErrFileExists
+		}
+		err = unix.Renameat(int(srcDir.Fd()), srcName, int(dstDir.Fd()), dstName)
+	}
+	if errors.Is(err, unix.EXDEV) {
+		return fmt.Errorf("failed to rename file: %v", err)
+	}
+	if err != nil {
+		return fileError(err, "rename file")
+	}
+	return nil
+}
+
+// Deletes the file, or the directory with everything in it
+func (m *Manager) Delete(c context.Context, p string) error {
+	loc, err := m.resolve(c, p)
+	if err != nil {
+		return err
+	}
+
+	if loc.isRoot() {
+		return ErrVolumeRoot
+	}
+
+	root, err := openRoot(loc)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	dir, name, err := root.openDir(loc)
+	if err != nil {
+		return fileError(err, "read directory")
+	}
+	defer dir.Close()
+
+	if err := exists(dir, name); err != nil {
+		return fileError(err, "read file")
+	}
+
+	if err := removeAllAt(int(dir.Fd()), name); err != nil {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+	return nil
+}
+
+// Writes to a temporary file next to the destination and moves it in place, so the game never reads a partial file.
+// The new file keeps the mode and owner of the one it replaces, or gets the owner of its directory.
+func (m *Manager) write(root *volumeRoot, loc location, r io.Reader, limit int64) error {
+	if loc.isRoot() {
+		return ErrIsDirectory
+	}
+
+	dir, name, err := root.openDir(loc)
+	if err != nil {
+		return fileError(err, "read directory")
+	}
+	defer dir.Close()
+
+	dirInfo, err := dir.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %v", err)
+	}
+
+	mode := fs.FileMode(0o644)
+	owner := dirInfo
+	if info, err := root.stat(loc.rel); err == nil {
+		if info.IsDir() {
+			return ErrIsDirectory
+		}
+		mode = info.Mode().Perm()
+		owner = info
+	} else if !errors.Is(err, unix.ENOENT) {
+		return fileError(err, "read file")
+	}
+
+	tmp, tmpName, err := createTemp(dir, name)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer unix.Unlinkat(int(dir.Fd()), tmpName, 0)
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, io.LimitReader(r, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if n > limit {
+		return ErrFileTooLarge
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	// the game runs as its own user, the files must stay its own; this only works as root
+	if stat, ok := owner.Sys().(*syscall.Stat_t); ok {
+		tmp.Chown(int(stat.Uid), int(stat.Gid))
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+
+	if err := unix.Renameat(int(dir.Fd()), tmpName, int(dir.Fd()), name); err != nil {
+		return fileError(err, "save file")
+	}
+	return nil
+}
+
+// Creates a hidden file with a name of its own next to the file it is written for
+func createTemp(dir *os.File, name string) (*os.File, string, error) {
+	for {
+		tmpName := "." + name + "." + strconv.FormatUint(uint64(rand.Uint32()), 10) + ".tmp"
+		fd, err := unix.Openat(int(dir.Fd()), tmpName, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0o600)
+		if errors.Is(err, unix.EEXIST) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return os.NewFile(uintptr(fd), path.Join(dir.Name(), tmpName)), tmpName, nil
+	}
+}
+
+// Reads the start of the file to tell whether it is text
+func sniffText(f io.Reader) bool {
+	buf := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false
+	}
+
+	data := buf[:n]
+	if n == sniffSize {
+		// the last character may have been cut in half
+		data = trimPartialRune(data)
+	}
+	return isText(data)
+}
+
+// Drops an incomplete character at the end of the data
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// Text is valid UTF-8 without NUL bytes, which binary formats are full of
+func isText(data []byte) bool {
+	return bytes.IndexByte(data, 0) == -1 && utf8.Valid(data)
+}
@@ -0,0 +1,275 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+type staticVolumes []service.VolumeBinding
+
+func (v staticVolumes) GetVolumes(c context.Context) ([]service.VolumeBinding, error) {
+	return v, nil
+}
+
+// Creates a manager for a /data volume next to a directory holding a secret, with links in the volume leading to it
+func newTestManager(t *testing.T) (*Manager, string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	host := filepath.Join(dir, "data")
+	outside := filepath.Join(dir, "outside")
+
+	for name, content := range map[string]string{
+		filepath.Join(outside, "secret.txt"):       "secret",
+		filepath.Join(host, "level.dat"):           "level",
+		filepath.Join(host, "world", "region.mca"): "region",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for link, target := range map[string]string{
+		"escape":     "../outside",
+		"abs":        outside,
+		"secretlink": "../outside/secret.txt",
+		"inside":     "world",
+		"world/up":   "../level.dat",
+	} {
+		if err := os.Symlink(target, filepath.Join(host, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	volumes := staticVolumes{{Container: "/data", Host: host}}
+	return NewManager(volumes, 1024, 4096), host, outside
+}
+
+// Fails unless the directory outside of the volume still holds the secret and nothing else
+func assertOutsideUntouched(t *testing.T, outside string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatalf("failed to read the directory outside of the volume: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "secret.txt" {
+		t.Fatalf("outside of the volume = %v, want only secret.txt", entries)
+	}
+	if data, err := os.ReadFile(filepath.Join(outside, "secret.txt")); err != nil || string(data) != "secret" {
+		t.Fatalf("secret = %q, %v, want it untouched", data, err)
+	}
+}
+
+func TestManagerConfinesLinks(t *testing.T) {
+	ctx := context.Background()
+	m, _, outside := newTestManager(t)
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{"list through a link", func() error { _, err := m.List(ctx, "/data/escape"); return err }},
+		{"read a link", func() error { _, err := m.ReadText(ctx, "/data/secretlink"); return err }},
+		{"read through a link", func() error { _, err := m.ReadText(ctx, "/data/escape/secret.txt"); return err }},
+		{"read through an absolute link", func() error { _, err := m.ReadText(ctx, "/data/abs/secret.txt"); return err }},
+		{"open through a link", func() error { _, _, err := m.Open(ctx, "/data/escape/secret.txt"); return err }},
+		{"write a link", func() error { return m.WriteText(ctx, "/data/secretlink", "stolen") }},
+		{"write through a link", func() error { return m.WriteText(ctx, "/data/escape/secret.txt", "stolen") }},
+		{"create through a link", func() error { return m.WriteText(ctx, "/data/escape/new.txt", "new") }},
+		{"upload through a link", func() error {
+			_, err := m.Upload(ctx, "/data/escape", "new.txt", strings.NewReader("new"))
+			return err
+		}},
+		{"make a directory through a link", func() error { return m.MakeDir(ctx, "/data/escape/plugins") }},
+		{"rename out through a link", func() error { return m.Rename(ctx, "/data/level.dat", "/data/escape/level.dat") }},
+		{"rename in through a link", func() error { return m.Rename(ctx, "/data/escape/secret.txt", "/data/stolen.txt") }},
+		{"delete through a link", func() error { return m.Delete(ctx, "/data/escape/secret.txt") }},
+		{"delete through an absolute link", func() error { return m.Delete(ctx, "/data/abs/secret.txt") }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.run(); !errors.Is(err, ErrInvalidPath) {
+				t.Fatalf("error = %v, want ErrInvalidPath", err)
+			}
+			assertOutsideUntouched(t, outside)
+		})
+	}
+}
+
+func TestManagerFollowsLinksInsideVolume(t *testing.T) {
+	ctx := context.Background()
+	m, _, _ := newTestManager(t)
+
+	if content, err := m.ReadText(ctx, "/data/inside/region.mca"); err != nil || content != "region" {
+		t.Fatalf("ReadText = %q, %v, want region", content, err)
+	}
+	if content, err := m.ReadText(ctx, "/data/world/up"); err != nil || content != "level" {
+		t.Fatalf("ReadText = %q, %v, want level", content, err)
+	}
+
+	entries, err := m.List(ctx, "/data/inside")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if strings.Join(names, ",") != "region.mca,up" {
+		t.Fatalf("List = %v, want region.mca and up", names)
+	}
+}
+
+func TestManagerDeletesLinksNotTargets(t *testing.T) {
+	ctx := context.Background()
+	m, host, outside := newTestManager(t)
+
+	// a directory holding a link out of the volume
+	if err := os.Symlink("../../outside", filepath.Join(host, "world", "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Delete(ctx, "/data/escape"); err != nil {
+		t.Fatalf("Delete of a link: %v", err)
+	}
+	if err := m.Delete(ctx, "/data/world"); err != nil {
+		t.Fatalf("Delete of a directory: %v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(host, "world")); !os.IsNotExist(err) {
+		t.Fatalf("the directory was kept: %v", err)
+	}
+	assertOutsideUntouched(t, outside)
+
+	if err := m.Delete(ctx, "/data"); !errors.Is(err, ErrVolumeRoot) {
+		t.Fatalf("Delete of the volume error = %v, want ErrVolumeRoot", err)
+	}
+	if err := m.Delete(ctx, "/data/missing"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("Delete of a missing file error = %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestManagerFiles(t *testing.T) {
+	ctx := context.Background()
+	m, host, _ := newTestManager(t)
+
+	if err := m.MakeDir(ctx, "/data/plugins/essentials"); err != nil {
+		t.Fatalf("MakeDir: %v", err)
+	}
+	if err := m.MakeDir(ctx, "/data/plugins/essentials"); err != nil {
+		t.Fatalf("MakeDir of an existing directory: %v", err)
+	}
+	if err := m.MakeDir(ctx, "/data/level.dat/plugins"); !errors.Is(err, ErrNotDirectory) {
+		t.Fatalf("MakeDir below a file error = %v, want ErrNotDirectory", err)
+	}
+
+	if err := m.WriteText(ctx, "/data/plugins/essentials/config.yml", "motd: hello"); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(host, "plugins", "essentials", "config.yml"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteText(ctx, "/data/plugins/essentials/config.yml", "motd: bye"); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if content, err := m.ReadText(ctx, "/data/plugins/essentials/config.yml"); err != nil || content != "motd: bye" {
+		t.Fatalf("ReadText = %q, %v, want the new content", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(host, "plugins", "essentials", "config.yml")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("the replaced file lost its mode: %v, %v", info, err)
+	}
+
+	writeErrors := []struct {
+		name    string
+		path    string
+		content string
+		want    error
+	}{
+		{"directory", "/data/plugins", "text", ErrIsDirectory},
+		{"volume", "/data", "text", ErrIsDirectory},
+		{"missing directory", "/data/mods/config.yml", "text", ErrFileNotFound},
+		{"binary", "/data/binary.dat", "\x00\x01", ErrBinaryFile},
+		{"too large", "/data/large.txt", strings.Repeat("a", 1025), ErrFileTooLarge},
+	}
+	for _, test := range writeErrors {
+		if err := m.WriteText(ctx, test.path, test.content); !errors.Is(err, test.want) {
+			t.Errorf("WriteText of a %s error = %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	entry, err := m.Upload(ctx, "/data/plugins", "essentials.jar", strings.NewReader("\x00jar"))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if entry.Path != "/data/plugins/essentials.jar" || entry.Size != 4 || entry.Editable {
+		t.Fatalf("uploaded entry = %+v", entry)
+	}
+	if _, err := m.Upload(ctx, "/data/plugins", "large.jar", strings.NewReader(strings.Repeat("a", 4097))); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("Upload of a large file error = %v, want ErrFileTooLarge", err)
+	}
+
+	if err := m.Rename(ctx, "/data/plugins/essentials.jar", "/data/plugins/essentials/essentials.jar"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := m.Rename(ctx, "/data/level.dat", "/data/plugins/essentials/config.yml"); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("Rename onto a file error = %v, want ErrFileExists", err)
+	}
+	if err := m.Rename(ctx, "/data/missing", "/data/other"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("Rename of a missing file error = %v, want ErrFileNotFound", err)
+	}
+	if err := m.Rename(ctx, "/data", "/data/other"); !errors.Is(err, ErrVolumeRoot) {
+		t.Fatalf("Rename of the volume error = %v, want ErrVolumeRoot", err)
+	}
+
+	f, info, err := m.Open(ctx, "/data/plugins/essentials/essentials.jar")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "\x00jar" || info.Name() != "essentials.jar" {
+		t.Fatalf("Open = %q named %s", data, info.Name())
+	}
+
+	entries, err := m.List(ctx, "/data/plugins/essentials")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "config.yml" || !entries[0].Editable || entries[1].Name != "essentials.jar" {
+		t.Fatalf("List = %+v", entries)
+	}
+	// temporary files are not left behind
+	if names, _ := filepath.Glob(filepath.Join(host, "plugins", "essentials", ".*")); len(names) != 0 {
+		t.Fatalf("left behind %v", names)
+	}
+
+	if _, err := m.List(ctx, "/data/level.dat"); !errors.Is(err, ErrNotDirectory) {
+		t.Fatalf("List of a file error = %v, want ErrNotDirectory", err)
+	}
+	if _, err := m.ReadText(ctx, "/data/world"); !errors.Is(err, ErrIsDirectory) {
+		t.Fatalf("ReadText of a directory error = %v, want ErrIsDirectory", err)
+	}
+}
+
+func TestManagerMissingVolume(t *testing.T) {
+	m := NewManager(staticVolumes{{Container: "/data", Host: filepath.Join(t.TempDir(), "data")}}, 1024, 4096)
+
+	if _, err := m.List(context.Background(), "/data"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("List error = %v, want ErrFileNotFound", err)
+	}
+	if _, err := m.List(context.Background(), "/"); err != nil {
+		t.Fatalf("List of the volumes: %v", err)
+	}
+}
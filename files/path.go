@@ -0,0 +1,86 @@
+package files
+
+import (
+	"path"
+	"strings"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+// File in a volume, addressed by its path below the directory of the volume
+type location struct {
+	// Path as seen inside the container, which is how files are addressed in the api
+	virtual string
+	// Slash separated path below the host directory of the volume, empty for the directory itself
+	rel    string
+	volume service.VolumeBinding
+}
+
+// Whether the location is the directory a volume is mounted on
+func (l location) isRoot() bool {
+	return l.rel == ""
+}
+
+// Gets the path of the directory containing the location, and its name in it
+func (l location) split() (string, string) {
+	dir, name := path.Split(l.rel)
+	return strings.TrimSuffix(dir, "/"), name
+}
+
+func (l location) child(name string) location {
+	return location{
+		virtual: path.Join(l.virtual, name),
+		rel:     strings.TrimPrefix(path.Join(l.rel, name), "/"),
+		volume:  l.volume,
+	}
+}
+
+// Cleans a path given by the user, paths with .. segments are rejected rather than cleaned away
+func cleanPath(p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", ErrInvalidPath
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", ErrInvalidPath
+		}
+	}
+	return path.Clean("/" + p), nil
+}
+
+// Finds the volume containing the container path and the path of the file below its host directory.
+// Links are not looked at here, the file is opened relative to the volume when it is used.
+func resolve(volumes []service.VolumeBinding, p string) (location, error) {
+	clean, err := cleanPath(p)
+	if err != nil {
+		return location{}, err
+	}
+
+	// the most specific volume wins when volumes are mounted inside each other
+	found := false
+	var volume service.VolumeBinding
+	for _, v := range volumes {
+		dest := path.Clean("/" + v.Container)
+		if clean != dest && !strings.HasPrefix(clean, strings.TrimSuffix(dest, "/")+"/") {
+			continue
+		}
+		if !found || len(dest) > len(path.Clean("/"+volume.Container)) {
+			volume = v
+			found = true
+		}
+	}
+	if !found {
+		return location{}, ErrInvalidPath
+	}
+
+	return location{
+		virtual: clean,
+		rel:     strings.TrimPrefix(strings.TrimPrefix(clean, path.Clean("/"+volume.Container)), "/"),
+		volume:  volume,
+	}, nil
+}
+
+// Checks the name of a new file, it must not contain a directory
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
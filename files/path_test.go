@@ -0,0 +1,116 @@
+package files
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr error
+	}{
+		{"", "/", nil},
+		{"/", "/", nil},
+		{"data", "/data", nil},
+		{"/data/world/", "/data/world", nil},
+		{"/data//world/./level.dat", "/data/world/level.dat", nil},
+		{"/data/..world", "/data/..world", nil},
+		{"/data/../etc/passwd", "", ErrInvalidPath},
+		{"..", "", ErrInvalidPath},
+		{"/data/world/..", "", ErrInvalidPath},
+		{"/data/level.dat\x00.txt", "", ErrInvalidPath},
+	}
+
+	for _, test := range tests {
+		got, err := cleanPath(test.path)
+		if !errors.Is(err, test.wantErr) || got != test.want {
+			t.Errorf("cleanPath(%q) = %q, %v, want %q, %v", test.path, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	volumes := []service.VolumeBinding{
+		{Container: "/data", Host: "/srv/instance/data"},
+		{Container: "/data/worlds/", Host: "/srv/instance/worlds"},
+		{Container: "/config", Host: "/srv/instance/config"},
+	}
+
+	tests := []struct {
+		path       string
+		wantRel    string
+		wantVolume string
+		wantErr    error
+	}{
+		{"/data", "", "/data", nil},
+		{"/data/level.dat", "level.dat", "/data", nil},
+		{"/data/plugins/essentials/config.yml", "plugins/essentials/config.yml", "/data", nil},
+		// the most specific volume wins
+		{"/data/worlds", "", "/data/worlds/", nil},
+		{"/data/worlds/Dedicated.db", "Dedicated.db", "/data/worlds/", nil},
+		{"/data/worldsbackup/old.db", "worldsbackup/old.db", "/data", nil},
+		{"config/server.cfg", "server.cfg", "/config", nil},
+		{"/", "", "", ErrInvalidPath},
+		{"/etc/passwd", "", "", ErrInvalidPath},
+		{"/database", "", "", ErrInvalidPath},
+		{"/data/../etc/passwd", "", "", ErrInvalidPath},
+	}
+
+	for _, test := range tests {
+		loc, err := resolve(volumes, test.path)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("resolve(%q) error = %v, want %v", test.path, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if loc.rel != test.wantRel || loc.volume.Container != test.wantVolume {
+			t.Errorf("resolve(%q) = %q in %q, want %q in %q", test.path, loc.rel, loc.volume.Container, test.wantRel, test.wantVolume)
+		}
+	}
+}
+
+func TestLocationSplit(t *testing.T) {
+	tests := []struct {
+		rel      string
+		wantDir  string
+		wantName string
+	}{
+		{"level.dat", "", "level.dat"},
+		{"world/region/r.0.0.mca", "world/region", "r.0.0.mca"},
+	}
+
+	for _, test := range tests {
+		dir, name := location{rel: test.rel}.split()
+		if dir != test.wantDir || name != test.wantName {
+			t.Errorf("split(%q) = %q, %q, want %q, %q", test.rel, dir, name, test.wantDir, test.wantName)
+		}
+	}
+}
+
+func TestValidName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"server.properties", true},
+		{".hidden", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"plugins/evil.jar", false},
+		{"..\\evil.jar", false},
+		{"evil\x00.jar", false},
+	}
+
+	for _, test := range tests {
+		if got := validName(test.name); got != test.want {
+			t.Errorf("validName(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+
+	"golang.org/x/sys/unix"
+)
+
+// Times an open is retried when the kernel could not rule out a concurrent rename moving it out of the volume
+const openRetries = 8
+
+// Open directory of a volume. Files below it are opened relative to it with openat2, so the kernel resolves
+// every path without leaving the volume, even when a link is swapped in while the file is being worked on.
+type volumeRoot struct {
+	fd   int
+	host string
+}
+
+func openRoot(loc location) (*volumeRoot, error) {
+	fd, err := unix.Open(loc.volume.Host, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to open volume %s: %v", loc.volume.Container, err)
+	}
+	return &volumeRoot{fd: fd, host: loc.volume.Host}, nil
+}
+
+func (r *volumeRoot) Close() error {
+	return unix.Close(r.fd)
+}
+
+// Opens the path below the volume, links are followed as long as they stay within it
+func (r *volumeRoot) open(rel string, flags int) (*os.File, error) {
+	return r.openAt(r.fd, rel, flags)
+}
+
+// Opens the path below the directory without leaving it
+func (r *volumeRoot) openAt(dirfd int, rel string, flags int) (*os.File, error) {
+	if rel == "" {
+		rel = "."
+	}
+
+	how := &unix.OpenHow{
+		Flags:   uint64(flags | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+
+	var fd int
+	var err error
+	for i := 0; i < openRetries; i++ {
+		if fd, err = unix.Openat2(dirfd, rel, how); !errors.Is(err, unix.EAGAIN) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), path.Join(r.host, rel)), nil
+}
+
+// Opens the directory of the location, which is where it is created, renamed or deleted
+func (r *volumeRoot) openDir(loc location) (*os.File, string, error) {
+	dir, name := loc.split()
+	f, err := r.open(dir, unix.O_RDONLY|unix.O_DIRECTORY)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, name, nil
+}
+
+// Gets the info of the file below the volume, following links within it
+func (r *volumeRoot) stat(rel string) (fs.FileInfo, error) {
+	return r.statWith(rel, unix.O_PATH)
+}
+
+// Gets the info of the file below the volume, or of the link when it is one
+func (r *volumeRoot) lstat(rel string) (fs.FileInfo, error) {
+	return r.statWith(rel, unix.O_PATH|unix.O_NOFOLLOW)
+}
+
+func (r *volumeRoot) statWith(rel string, flags int) (fs.FileInfo, error) {
+	f, err := r.open(rel, flags)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// Checks that the directory holds the name, a link is not followed
+func exists(dir *os.File, name string) error {
+	var stat unix.Stat_t
+	return unix.Fstatat(int(dir.Fd()), name, &stat, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// Deletes the name in the directory, and everything in it when it is a directory. Links are deleted, not followed.
+func removeAllAt(dirfd int, name string) error {
+	err := unix.Unlinkat(dirfd, name, 0)
+	if err == nil || errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if !errors.Is(err, unix.EISDIR) {
+		return err
+	}
+
+	fd, err := unix.Openat(dirfd, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	dir := os.NewFile(uintptr(fd), name)
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	for _, child := range names {
+		if err := removeAllAt(fd, child); err != nil {
+			return err
+		}
+	}
+
+	if err := unix.Unlinkat(dirfd, name, unix.AT_REMOVEDIR); err != nil && !errors.Is(err, unix.ENOENT) {
+		return err
+	}
+	return nil
+}
+
+// Turns the error of a file operation into the errors of the package, other errors are wrapped with the action
+func fileError(err error, action string) error {
+	switch {
+	case errors.Is(err, unix.ENOENT):
+		return ErrFileNotFound
+	case errors.Is(err, unix.EXDEV), errors.Is(err, unix.ELOOP):
+		// the path leads out of the volume through a link
+		return ErrInvalidPath
+	case errors.Is(err, unix.ENOTDIR):
+		return ErrNotDirectory
+	case errors.Is(err, unix.EISDIR):
+		return ErrIsDirectory
+	case errors.Is(err, unix.EEXIST):
+		return ErrFileExists
+	}
+	return fmt.Errorf("failed to %s: %v", action, err)
+}
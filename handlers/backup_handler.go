@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/backup"
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+func GetBackups(c *gin.Context, appCtx *app.Context) {
+	backups, err := appCtx.Backups.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get backups", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"backups": backups})
+}
+
+func CreateBackup(c *gin.Context, appCtx *app.Context) {
+	created, err := appCtx.Backups.Create(c, internal.BackupTriggerManual)
+	if err != nil {
+		handleBackupError(c, "Failed to create backup", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+func DownloadBackup(c *gin.Context, appCtx *app.Context) {
+	found, ok := getBackup(c, appCtx)
+	if !ok {
+		return
+	}
+
+	archive, err := appCtx.Backups.Open(c, found)
+	if err != nil {
+		handleBackupError(c, "Failed to download backup", err)
+		return
+	}
+	defer archive.Close()
+
+	c.DataFromReader(http.StatusOK, found.Size, "application/gzip", archive, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, found.Name),
+	})
+}
+
+func DeleteBackup(c *gin.Context, appCtx *app.Context) {
+	ID, ok := backupID(c)
+	if !ok {
+		return
+	}
+
+	if err := appCtx.Backups.Delete(c, ID); err != nil {
+		handleBackupError(c, "Failed to delete backup", err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func RestoreBackup(c *gin.Context, appCtx *app.Context) {
+	ID, ok := backupID(c)
+	if !ok {
+		return
+	}
+
+	if err := appCtx.Backups.Restore(c, ID); err != nil {
+		handleBackupError(c, "Failed to restore backup", err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func getBackup(c *gin.Context, appCtx *app.Context) (internal.Backup, bool) {
+	ID, ok := backupID(c)
+	if !ok {
+		return internal.Backup{}, false
+	}
+
+	found, err := appCtx.Backups.Get(ID)
+	if err != nil {
+		handleBackupError(c, "Failed to get backup", err)
+		return internal.Backup{}, false
+	}
+	return found, true
+}
+
+func backupID(c *gin.Context) (uint, bool) {
+	ID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup id"})
+		return 0, false
+	}
+	return uint(ID), true
+}
+
+// Responds with the status matching the backup error
+func handleBackupError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, backup.ErrBackupNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+	case errors.Is(err, backup.ErrBackupInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": message, "details": err.Error()})
+	default:
+		handleServiceError(c, message, err)
+	}
+}
@@ -1,14 +1,15 @@
 package handlers
 
 import (
-	"io"
+	"errors"
+	"log"
 	"net/http"
-	"strings"
+	"strconv"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"github.com/gin-gonic/gin"
 	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/history"
+	"github.com/mooncorn/gshub-server-api/service"
 )
 
 func RunCommand(c *gin.Context, appCtx *app.Context) {
@@ -22,55 +23,37 @@ func RunCommand(c *gin.Context, appCtx *app.Context) {
 		return
 	}
 
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Docker client"})
-		return
-	}
+	result, err := appCtx.ServiceController.RunCommand(c, request.Cmd)
 
-	formattedCmd, err := appCtx.ServiceController.FormatGameCommand(request.Cmd)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Feature not supported"})
+	// a rejected command never ran, so it is not part of the history
+	var policyErr *service.PolicyError
+	if errors.As(err, &policyErr) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Command rejected", "details": policyErr})
 		return
 	}
 
-	// Command to execute
-	execConfig := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"/bin/bash", "-c", formattedCmd},
-	}
+	recordCommand(c, appCtx, request.Cmd, result, err)
 
-	execID, err := cli.ContainerExecCreate(c, "main", execConfig)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create exec instance"})
-		return
-	}
 
-	// Attach to exec instance
-	resp, err := cli.ContainerExecAttach(c, execID.ID, types.ExecStartCheck{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach to exec instance"})
-		return
-	}
-	defer resp.Close()
+		if errors.Is(err, service.ErrNotSupported) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Feature not supported"})
+			return
+		}
 
-	// Read output from exec instance
-	output, err := io.ReadAll(resp.Reader)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read exec output"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run command", "details": err.Error()})
 		return
 	}
 
-	cleanOutput := ""
-
-	// Remove padding
-	if len(string(output)) >= 8 {
-		cleanOutput = string(output)[8:]
-	}
+	c.JSON(http.StatusOK, gin.H{"output": service.LineTexts(result.Output), "lines": result.Output, "exitCode": result.ExitCode})
+}
 
-	outputArray := strings.Split(cleanOutput, "\n")
-	outputArray = outputArray[:len(outputArray)-1]
+// Adds the command to the history, a failure to do so does not fail the request
+func recordCommand(c *gin.Context, appCtx *app.Context, cmd string, result service.ExecResult, runErr error) {
+	userID, _ := strconv.ParseUint(c.GetString("userID"), 10, 32)
 
-	c.JSON(http.StatusOK, gin.H{"output": outputArray})
+	record := history.NewCommandRecord(uint(userID), cmd, result, runErr)
+	if err := history.RecordCommand(appCtx.DB, &record); err != nil {
+		log.Printf("Failed to record command: %v", err)
+	}
 }
@@ -1,46 +1,158 @@
 package handlers
 
 import (
+	"errors"
 	"io"
+	"log"
 	"net/http"
-	"strings"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/service"
 )
 
 func GetConsole(c *gin.Context, appCtx *app.Context) {
-	apiClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	console, err := appCtx.ServiceController.GetConsole(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create docker client"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get console logs", "details": err.Error()})
 		return
 	}
-	defer apiClient.Close()
 
-	out, err := apiClient.ContainerLogs(c, "main", container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	c.JSON(http.StatusOK, gin.H{"console": service.LineTexts(console), "lines": console})
+}
+
+// How many viewers can follow the console at the same time
+const maxConsoleViewers = 5
+
+var consoleViewers = make(chan struct{}, maxConsoleViewers)
+
+// Streams the console as server-sent events as lines arrive.
+// Accepts tail, the number of previous lines to send first, and since, a timestamp or a relative time like 10m.
+func StreamConsole(c *gin.Context, appCtx *app.Context) {
+	tail := c.DefaultQuery("tail", "100")
+	if _, err := strconv.Atoi(tail); err != nil && tail != "all" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tail"})
+		return
+	}
+
+	select {
+	case consoleViewers <- struct{}{}:
+		defer func() { <-consoleViewers }()
+	default:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many console viewers"})
+		return
+	}
+
+	lines, errs := appCtx.ServiceController.FollowConsole(c.Request.Context(), tail, c.Query("since"))
+
+	c.Stream(func(w io.Writer) bool {
+		line, ok := <-lines
+		if !ok {
+			select {
+			case err := <-errs:
+				c.SSEvent("error", gin.H{"error": "Failed to follow console", "details": err.Error()})
+			default:
+			}
+			return false
+		}
+
+		c.SSEvent("line", line)
+		return true
+	})
+}
+
+// How long writing a line to a console websocket may take before the viewer is dropped
+const consoleWriteTimeout = 10 * time.Second
+
+var consoleUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || origin == "http://localhost:3000"
+	},
+}
+
+// Attaches to the stdin and stdout of the game over a websocket.
+// Every text message received is sent to the game as a line, every line of output is sent back as JSON.
+func AttachConsole(c *gin.Context, appCtx *app.Context) {
+	select {
+	case consoleViewers <- struct{}{}:
+		defer func() { <-consoleViewers }()
+	default:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many console viewers"})
+		return
+	}
+
+	session, err := appCtx.ServiceController.AttachConsole(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get console logs stream", "details": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach to console", "details": err.Error()})
 		return
 	}
+	defer session.Close()
 
-	logs, err := io.ReadAll(out)
+	conn, err := consoleUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read console logs", "details": err.Error()})
+		// the upgrader already responded with an error
 		return
 	}
+	defer conn.Close()
 
-	// Remove padding
-	cleanLogs := []string{}
-	logsArray := strings.Split(string(logs), "\n")
-	for _, line := range logsArray {
-		if len(line) <= 8 {
-			continue
-		}
-		cleanLogs = append(cleanLogs, line[8:])
+	// the input and output loops both write to the viewer
+	var writeMu sync.Mutex
+	writeJSON := func(v interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(consoleWriteTimeout))
+		return conn.WriteJSON(v)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"console": cleanLogs})
+	// Forward input from the viewer to the game until the viewer goes away.
+	// Every line is a command, checked and recorded like a command run through the api.
+	go func() {
+		defer session.Close()
+
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
 
+			for _, line := range service.ConsoleInputLines(string(message)) {
+				// a rejected command never ran, so it is not part of the history
+				if err := appCtx.ServiceController.CheckCommand(c, line); err != nil {
+					var policyErr *service.PolicyError
+					if errors.As(err, &policyErr) {
+						writeJSON(gin.H{"error": "Command rejected", "details": policyErr})
+					} else {
+						writeJSON(gin.H{"error": "Failed to check command", "details": err.Error()})
+					}
+					continue
+				}
+
+				err := session.Send(line)
+				recordCommand(c, appCtx, line, service.ExecResult{}, err)
+				if err != nil {
+					log.Printf("Failed to send console input: %v", err)
+					return
+				}
+			}
+		}
+	}()
+
+	// Forward output from the game to the viewer until the game or the viewer goes away
+	for {
+		line, err := session.Next()
+		if err != nil {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "console closed"), time.Now().Add(time.Second))
+			return
+		}
+
+		if err := writeJSON(line); err != nil {
+			return
+		}
+	}
 }
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/cycles"
+	"github.com/mooncorn/gshub-server-api/internal"
+)
+
+// Called by the main api when the owner purchases more cycles
+func PostTopUp(c *gin.Context, appCtx *app.Context) {
+	var request internal.TopUp
+
+	if err := c.BindJSON(&request); err != nil || request.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	applied, err := cycles.ApplyTopUp(appCtx.DB, appCtx.CycleMeter, request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply top-up", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"applied": applied, "budget": appCtx.CycleMeter.Budget()})
+}
+
+func GetCycles(c *gin.Context, appCtx *app.Context) {
+	status, err := cycles.GetStatus(appCtx.CycleMeter, appCtx.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cycles", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Streams the cycles status every second as server-sent events
+func StreamCycles(c *gin.Context, appCtx *app.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		status, err := cycles.GetStatus(appCtx.CycleMeter, appCtx.DB)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": "Failed to get cycles", "details": err.Error()})
+			return false
+		}
+
+		c.SSEvent("cycles", status)
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			return true
+		}
+	})
+}
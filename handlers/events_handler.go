@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/app"
+)
+
+// Gets whether the game is ready and who is online, as seen from its console
+func GetEventStatus(c *gin.Context, appCtx *app.Context) {
+	c.JSON(http.StatusOK, appCtx.EventEngine.Status())
+}
+
+// Streams console events as they happen, starting with the current status
+func StreamEvents(c *gin.Context, appCtx *app.Context) {
+	events, unsubscribe := appCtx.EventEngine.Subscribe()
+	defer unsubscribe()
+
+	c.SSEvent("status", appCtx.EventEngine.Status())
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+
+			c.SSEvent("event", event)
+			return true
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/files"
+)
+
+func ListFiles(c *gin.Context, appCtx *app.Context) {
+	path := c.DefaultQuery("path", "/")
+
+	entries, err := appCtx.Files.List(c, path)
+	if err != nil {
+		handleFileError(c, "Failed to list files", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"path": path, "files": entries})
+}
+
+func ReadFile(c *gin.Context, appCtx *app.Context) {
+	path := c.Query("path")
+
+	content, err := appCtx.Files.ReadText(c, path)
+	if err != nil {
+		handleFileError(c, "Failed to read file", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"path": path, "content": content})
+}
+
+func WriteFile(c *gin.Context, appCtx *app.Context) {
+	var request struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := appCtx.Files.WriteText(c, request.Path, request.Content); err != nil {
+		handleFileError(c, "Failed to write file", err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func UploadFile(c *gin.Context, appCtx *app.Context) {
+	// the form around the file is small, anything beyond the limit is rejected before it is stored
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, appCtx.Files.MaxUploadSize()+1024*1024)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			handleFileError(c, "Failed to upload file", files.ErrFileTooLarge)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	if header.Size > appCtx.Files.MaxUploadSize() {
+		handleFileError(c, "Failed to upload file", files.ErrFileTooLarge)
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	entry, err := appCtx.Files.Upload(c, c.DefaultPostForm("path", "/"), header.Filename, f)
+	if err != nil {
+		handleFileError(c, "Failed to upload file", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+func MakeDir(c *gin.Context, appCtx *app.Context) {
+	var request struct {
+		Path string `json:"path"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := appCtx.Files.MakeDir(c, request.Path); err != nil {
+		handleFileError(c, "Failed to create directory", err)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+func DownloadFile(c *gin.Context, appCtx *app.Context) {
+	f, info, err := appCtx.Files.Open(c, c.Query("path"))
+	if err != nil {
+		handleFileError(c, "Failed to download file", err)
+		return
+	}
+	defer f.Close()
+
+	c.DataFromReader(http.StatusOK, info.Size(), "application/octet-stream", f, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, info.Name()),
+	})
+}
+
+func RenameFile(c *gin.Context, appCtx *app.Context) {
+	var request struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := appCtx.Files.Rename(c, request.From, request.To); err != nil {
+		handleFileError(c, "Failed to rename file", err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func DeleteFile(c *gin.Context, appCtx *app.Context) {
+	if err := appCtx.Files.Delete(c, c.Query("path")); err != nil {
+		handleFileError(c, "Failed to delete file", err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func handleFileError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, files.ErrFileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	case errors.Is(err, files.ErrInvalidPath), errors.Is(err, files.ErrIsDirectory),
+		errors.Is(err, files.ErrNotDirectory), errors.Is(err, files.ErrVolumeRoot):
+		c.JSON(http.StatusBadRequest, gin.H{"error": message, "details": err.Error()})
+	case errors.Is(err, files.ErrFileExists):
+		c.JSON(http.StatusConflict, gin.H{"error": message, "details": err.Error()})
+	case errors.Is(err, files.ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": message, "details": err.Error()})
+	case errors.Is(err, files.ErrBinaryFile):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": message, "details": err.Error()})
+	default:
+		handleServiceError(c, message, err)
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/history"
+)
+
+func GetCommandHistory(c *gin.Context, appCtx *app.Context) {
+	query, err := history.ParseQuery(c.Query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query", "details": err.Error()})
+		return
+	}
+
+	commands, total, err := history.SearchCommands(appCtx.DB, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get command history", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"commands": commands, "total": total, "page": query.Page, "pageSize": query.PageSize})
+}
+
+func GetConsoleHistory(c *gin.Context, appCtx *app.Context) {
+	query, err := history.ParseQuery(c.Query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query", "details": err.Error()})
+		return
+	}
+
+	lines, more, err := history.SearchConsole(history.DefaultArchiveDir, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get console history", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"console": lines, "hasMore": more, "page": query.Page, "pageSize": query.PageSize})
+}
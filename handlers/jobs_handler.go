@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/scheduler"
+)
+
+func GetJobs(c *gin.Context, appCtx *app.Context) {
+	jobs, err := appCtx.Scheduler.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get jobs", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+func GetJob(c *gin.Context, appCtx *app.Context) {
+	ID, ok := jobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := appCtx.Scheduler.Get(ID)
+	if err != nil {
+		handleJobError(c, "Failed to get job", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func CreateJob(c *gin.Context, appCtx *app.Context) {
+	var request struct {
+		Name     string `json:"name"`
+		Schedule string `json:"schedule"`
+		Action   string `json:"action"`
+		Command  string `json:"command"`
+		// Jobs are enabled unless told otherwise
+		Enabled *bool `json:"enabled"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	job, err := appCtx.Scheduler.Create(c, internal.ScheduledJob{
+		Name:     request.Name,
+		Schedule: request.Schedule,
+		Action:   request.Action,
+		Command:  request.Command,
+		Enabled:  request.Enabled == nil || *request.Enabled,
+	})
+	if err != nil {
+		handleJobError(c, "Failed to create job", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, job)
+}
+
+func UpdateJob(c *gin.Context, appCtx *app.Context) {
+	ID, ok := jobID(c)
+	if !ok {
+		return
+	}
+
+	var changes scheduler.JobChanges
+	if err := c.BindJSON(&changes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	job, err := appCtx.Scheduler.Update(c, ID, changes)
+	if err != nil {
+		handleJobError(c, "Failed to update job", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func DeleteJob(c *gin.Context, appCtx *app.Context) {
+	ID, ok := jobID(c)
+	if !ok {
+		return
+	}
+
+	if err := appCtx.Scheduler.Delete(ID); err != nil {
+		handleJobError(c, "Failed to delete job", err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func RunJob(c *gin.Context, appCtx *app.Context) {
+	ID, ok := jobID(c)
+	if !ok {
+		return
+	}
+
+	if err := appCtx.Scheduler.Run(ID); err != nil {
+		handleJobError(c, "Failed to run job", err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func jobID(c *gin.Context) (uint, bool) {
+	ID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job id"})
+		return 0, false
+	}
+	return uint(ID), true
+}
+
+func handleJobError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, scheduler.ErrInvalidJob):
+		c.JSON(http.StatusBadRequest, gin.H{"error": message, "details": err.Error()})
+	case errors.Is(err, scheduler.ErrJobRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": message, "details": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message, "details": err.Error()})
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/app"
+	"github.com/mooncorn/gshub-server-api/files"
+	"github.com/mooncorn/gshub-server-api/mods"
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+func GetMods(c *gin.Context, appCtx *app.Context) {
+	layout, err := appCtx.Mods.Layout(c)
+	if err != nil {
+		handleModError(c, "Failed to get mods", err)
+		return
+	}
+
+	installed, err := appCtx.Mods.List(c)
+	if err != nil {
+		handleModError(c, "Failed to get mods", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"layout": layout, "mods": installed, "restartAt": appCtx.Mods.RestartAt()})
+}
+
+func UploadMod(c *gin.Context, appCtx *app.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, appCtx.Files.MaxUploadSize()+1024*1024)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			handleModError(c, "Failed to install mod", files.ErrFileTooLarge)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	defer f.Close()
+
+	installed, err := appCtx.Mods.Install(c, header.Filename, f, c.PostForm("checksum"))
+	if err != nil {
+		handleModError(c, "Failed to install mod", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"mod": installed, "restartAt": appCtx.Mods.RestartAt()})
+}
+
+func InstallModFromUrl(c *gin.Context, appCtx *app.Context) {
+	var request struct {
+		Url string `json:"url"`
+		// File name of the mod, taken from the url when empty
+		Name string `json:"name"`
+		// Hex encoded sha256 of the file
+		Checksum string `json:"checksum"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	installed, err := appCtx.Mods.InstallFromUrl(c, request.Name, request.Url, request.Checksum)
+	if err != nil {
+		handleModError(c, "Failed to install mod", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"mod": installed, "restartAt": appCtx.Mods.RestartAt()})
+}
+
+func EnableMod(c *gin.Context, appCtx *app.Context) {
+	setModEnabled(c, appCtx, true)
+}
+
+func DisableMod(c *gin.Context, appCtx *app.Context) {
+	setModEnabled(c, appCtx, false)
+}
+
+func setModEnabled(c *gin.Context, appCtx *app.Context, enabled bool) {
+	if err := appCtx.Mods.SetEnabled(c, c.Param("name"), enabled); err != nil {
+		handleModError(c, "Failed to change mod", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"restartAt": appCtx.Mods.RestartAt()})
+}
+
+func RemoveMod(c *gin.Context, appCtx *app.Context) {
+	if err := appCtx.Mods.Remove(c, c.Param("name")); err != nil {
+		handleModError(c, "Failed to remove mod", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"restartAt": appCtx.Mods.RestartAt()})
+}
+
+func handleModError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, mods.ErrModNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mod not found"})
+	case errors.Is(err, service.ErrNotSupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Mods are not supported by this server"})
+	case errors.Is(err, mods.ErrInvalidModName), errors.Is(err, mods.ErrInvalidModUrl),
+		errors.Is(err, mods.ErrChecksumMismatch), errors.Is(err, mods.ErrChecksumRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": message, "details": err.Error()})
+	default:
+		handleFileError(c, message, err)
+	}
+}
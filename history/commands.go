@@ -0,0 +1,62 @@
+package history
+
+import (
+	"fmt"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+	"gorm.io/gorm"
+)
+
+func RecordCommand(db *gorm.DB, record *internal.CommandRecord) error {
+	if err := db.Create(record).Error; err != nil {
+		return fmt.Errorf("failed to record command: %v", err)
+	}
+	return nil
+}
+
+// Builds the record of a command that was run, a command that failed to run is recorded with its error
+func NewCommandRecord(userID uint, cmd string, result service.ExecResult, runErr error) internal.CommandRecord {
+	record := internal.CommandRecord{
+		UserID:   userID,
+		Command:  cmd,
+		Output:   service.JoinLines(result.Output),
+		ExitCode: result.ExitCode,
+	}
+
+	if runErr != nil {
+		record.ExitCode = -1
+		record.Error = runErr.Error()
+	}
+	return record
+}
+
+// Returns a page of the commands matching the query, newest first, and the total number of matches
+func SearchCommands(db *gorm.DB, query Query) ([]internal.CommandRecord, int64, error) {
+	tx := db.Model(&internal.CommandRecord{})
+
+	if query.Text != "" {
+		pattern := "%" + query.Text + "%"
+		tx = tx.Where("command LIKE ? OR output LIKE ?", pattern, pattern)
+	}
+
+	if !query.From.IsZero() {
+		tx = tx.Where("created_at >= ?", query.From)
+	}
+
+	if !query.To.IsZero() {
+		tx = tx.Where("created_at <= ?", query.To)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count commands: %v", err)
+	}
+
+	records := []internal.CommandRecord{}
+	if err := tx.Order("created_at DESC").Offset(query.offset()).Limit(query.PageSize).Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get commands: %v", err)
+	}
+
+	return records, total, nil
+}
@@ -0,0 +1,324 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+const (
+	DefaultArchiveDir = "console-archive"
+	// Size at which the current archive file is rotated
+	DefaultMaxFileSize = 10 * 1024 * 1024
+	// Number of rotated files kept besides the current one
+	DefaultMaxFiles = 10
+
+	currentArchiveFile = "console.jsonl"
+	followRetryDelay   = 5 * time.Second
+	// Bytes read at once when the archive is read backwards
+	archiveChunkSize   = 64 * 1024
+	maxArchiveLineSize = 1024 * 1024
+)
+
+// ConsoleSource is the part of the service controller the archiver follows
+type ConsoleSource interface {
+	FollowConsole(c context.Context, tail string, since string) (<-chan service.LogLine, <-chan error)
+}
+
+// ConsoleArchiver keeps a rotating on-disk copy of the console, so it outlives the container.
+// Lines are stored as JSON, one per line.
+type ConsoleArchiver struct {
+	mu          sync.Mutex
+	source      ConsoleSource
+	dir         string
+	maxFileSize int64
+	maxFiles    int
+
+	file *os.File
+	size int64
+	last time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewConsoleArchiver(source ConsoleSource, dir string, maxFileSize int64, maxFiles int) *ConsoleArchiver {
+	return &ConsoleArchiver{
+		source:      source,
+		dir:         dir,
+		maxFileSize: maxFileSize,
+		maxFiles:    maxFiles,
+		done:        make(chan struct{}),
+	}
+}
+
+// Starts archiving in the background, resuming after the last archived line
+func (a *ConsoleArchiver) Start() error {
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create console archive: %v", err)
+	}
+
+	if err := a.openCurrent(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+
+	go a.run(ctx)
+	return nil
+}
+
+// Stops archiving and closes the current file
+func (a *ConsoleArchiver) Stop() {
+	if a.cancel == nil {
+		return
+	}
+
+	a.cancel()
+	<-a.done
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.file.Close()
+}
+
+func (a *ConsoleArchiver) run(ctx context.Context) {
+	defer close(a.done)
+
+	lastErr := ""
+	for {
+		since := ""
+		if !a.last.IsZero() {
+			since = fmt.Sprintf("%d.%09d", a.last.Unix(), a.last.Nanosecond())
+		}
+
+		lines, errs := a.source.FollowConsole(ctx, "all", since)
+		for line := range lines {
+			// the since filter of docker is not precise, skip what was archived already
+			if !line.Timestamp.After(a.last) {
+				continue
+			}
+
+			if err := a.write(line); err != nil {
+				log.Printf("Failed to archive console: %v", err)
+			}
+		}
+
+		select {
+		case err := <-errs:
+			// the container is missing or stopped most of the time, only log when something changes
+			if err.Error() != lastErr {
+				log.Printf("Console archive is waiting for the service: %v", err)
+				lastErr = err.Error()
+			}
+		default:
+			lastErr = ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(followRetryDelay):
+		}
+	}
+}
+
+func (a *ConsoleArchiver) write(line service.LogLine) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if a.size+int64(len(data)) > a.maxFileSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(data)
+	a.size += int64(n)
+	a.last = line.Timestamp
+	return err
+}
+
+// Opens the current archive file and finds the timestamp of its last line
+func (a *ConsoleArchiver) openCurrent() error {
+	path := filepath.Join(a.dir, currentArchiveFile)
+
+	err := scanArchiveBackward(path, func(line service.LogLine) bool {
+		a.last = line.Timestamp
+		return false
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open console archive: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open console archive: %v", err)
+	}
+
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// Moves the current file aside and removes the oldest rotated files over the limit
+func (a *ConsoleArchiver) rotate() error {
+	a.file.Close()
+
+	current := filepath.Join(a.dir, currentArchiveFile)
+	rotated := filepath.Join(a.dir, fmt.Sprintf("console-%d.jsonl", time.Now().UnixNano()))
+	if err := os.Rename(current, rotated); err != nil {
+		return fmt.Errorf("failed to rotate console archive: %v", err)
+	}
+
+	files, err := rotatedFiles(a.dir)
+	if err != nil {
+		return err
+	}
+
+	for len(files) > a.maxFiles {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+
+	file, err := os.OpenFile(current, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open console archive: %v", err)
+	}
+
+	a.file = file
+	a.size = 0
+	return nil
+}
+
+// Returns a page of the archived lines matching the query, newest first, and whether older matches follow.
+// The files are read backwards and only until the page is full.
+func SearchConsole(dir string, query Query) ([]service.LogLine, bool, error) {
+	files, err := rotatedFiles(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	files = append(files, filepath.Join(dir, currentArchiveFile))
+
+	text := strings.ToLower(query.Text)
+	matches := []service.LogLine{}
+	skipped := 0
+	more := false
+
+	// walk the files and their lines from newest to oldest
+	for i := len(files) - 1; i >= 0 && !more; i-- {
+		err := scanArchiveBackward(files[i], func(line service.LogLine) bool {
+			if !query.inRange(line.Timestamp) || !strings.Contains(strings.ToLower(line.Text), text) {
+				return true
+			}
+
+			if skipped < query.offset() {
+				skipped++
+				return true
+			}
+
+			if len(matches) == query.PageSize {
+				more = true
+				return false
+			}
+
+			matches = append(matches, line)
+			return true
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+	}
+
+	return matches, more, nil
+}
+
+// Returns the rotated archive files, oldest first
+func rotatedFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "console-*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list console archive: %v", err)
+	}
+
+	// the names contain the rotation time, so they sort chronologically
+	sort.Strings(files)
+	return files, nil
+}
+
+// Calls fn with the lines of the archive file from the last to the first, until fn returns false
+func scanArchiveBackward(path string, fn func(line service.LogLine) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read console archive: %v", err)
+	}
+
+	// emits a raw line, a torn write from a crash is skipped
+	emit := func(raw []byte) bool {
+		var line service.LogLine
+		if len(raw) == 0 || json.Unmarshal(raw, &line) != nil {
+			return true
+		}
+		return fn(line)
+	}
+
+	chunk := make([]byte, archiveChunkSize)
+	// the start of a line whose beginning is in an earlier chunk
+	var rest []byte
+	for pos := info.Size(); pos > 0; {
+		n := min(int64(len(chunk)), pos)
+		pos -= n
+
+		if _, err := file.ReadAt(chunk[:n], pos); err != nil {
+			return fmt.Errorf("failed to read console archive: %v", err)
+		}
+
+		data := append(append([]byte(nil), chunk[:n]...), rest...)
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			if !emit(data[i+1:]) {
+				return nil
+			}
+			data = data[:i]
+		}
+
+		if len(data) > maxArchiveLineSize {
+			return fmt.Errorf("failed to read console archive: line longer than %d bytes", maxArchiveLineSize)
+		}
+		rest = data
+	}
+
+	emit(rest)
+	return nil
+}
@@ -0,0 +1,111 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+var archiveStart = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// Writes an archive file with a line per second for the texts
+func writeArchive(t *testing.T, path string, first int, texts ...string) {
+	t.Helper()
+
+	var b strings.Builder
+	for i, text := range texts {
+		data, err := json.Marshal(service.LogLine{Stream: service.StreamStdout, Timestamp: archiveStart.Add(time.Duration(first+i) * time.Second), Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchConsole(t *testing.T) {
+	dir := t.TempDir()
+	writeArchive(t, filepath.Join(dir, "console-1.jsonl"), 0, "Steve joined", "old line", "Alex joined")
+	writeArchive(t, filepath.Join(dir, "console-2.jsonl"), 3, "Steve left", "Bob joined")
+	writeArchive(t, filepath.Join(dir, currentArchiveFile), 5, "Alex left", "Eve JOINED")
+
+	tests := []struct {
+		name     string
+		query    Query
+		want     []string
+		wantMore bool
+	}{
+		{"all", Query{Page: 1, PageSize: 50}, []string{"Eve JOINED", "Alex left", "Bob joined", "Steve left", "Alex joined", "old line", "Steve joined"}, false},
+		{"text ignores case", Query{Text: "joined", Page: 1, PageSize: 50}, []string{"Eve JOINED", "Bob joined", "Alex joined", "Steve joined"}, false},
+		{"first page", Query{Text: "joined", Page: 1, PageSize: 2}, []string{"Eve JOINED", "Bob joined"}, true},
+		{"page across files", Query{Text: "joined", Page: 2, PageSize: 2}, []string{"Alex joined", "Steve joined"}, false},
+		{"past the end", Query{Page: 3, PageSize: 5}, []string{}, false},
+		{"time range", Query{From: archiveStart.Add(2 * time.Second), To: archiveStart.Add(4 * time.Second), Page: 1, PageSize: 50}, []string{"Bob joined", "Steve left", "Alex joined"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, more, err := SearchConsole(dir, test.query)
+			if err != nil {
+				t.Fatalf("SearchConsole: %v", err)
+			}
+			if got := service.LineTexts(lines); strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Fatalf("SearchConsole = %q, want %q", got, test.want)
+			}
+			if more != test.wantMore {
+				t.Fatalf("more = %v, want %v", more, test.wantMore)
+			}
+		})
+	}
+}
+
+func TestSearchConsoleWithoutArchive(t *testing.T) {
+	lines, more, err := SearchConsole(t.TempDir(), Query{Page: 1, PageSize: 10})
+	if err != nil || len(lines) != 0 || more {
+		t.Fatalf("SearchConsole = %v, %v, %v, want nothing", lines, more, err)
+	}
+}
+
+func TestScanArchiveBackwardAcrossChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), currentArchiveFile)
+
+	// enough lines for several chunks, so lines are split between them
+	var lines []string
+	for i := 0; i < 3*archiveChunkSize/50; i++ {
+		lines = append(lines, fmt.Sprintf("line %d %s", i, strings.Repeat("x", i%40)))
+	}
+	writeArchive(t, path, 0, lines...)
+
+	// a torn write at the end is skipped
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"stream":"std`)
+	f.Close()
+
+	i := len(lines) - 1
+	err = scanArchiveBackward(path, func(line service.LogLine) bool {
+		if line.Text != lines[i] {
+			t.Fatalf("line %d = %q, want %q", i, line.Text, lines[i])
+		}
+		i--
+		return true
+	})
+	if err != nil {
+		t.Fatalf("scanArchiveBackward: %v", err)
+	}
+	if i != -1 {
+		t.Fatalf("%d lines were not read", i+1)
+	}
+}
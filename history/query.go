@@ -0,0 +1,73 @@
+package history
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Query filters and pages through history entries
+type Query struct {
+	// Text the entry has to contain, matched case insensitively
+	Text string
+	From time.Time
+	To   time.Time
+	// Page number starting at 1
+	Page     int
+	PageSize int
+}
+
+// Parses the q, from, to, page and pageSize query parameters, times are RFC3339
+func ParseQuery(get func(key string) string) (Query, error) {
+	query := Query{
+		Text:     get("q"),
+		Page:     1,
+		PageSize: defaultPageSize,
+	}
+
+	var err error
+	if value := get("from"); value != "" {
+		if query.From, err = time.Parse(time.RFC3339, value); err != nil {
+			return Query{}, fmt.Errorf("invalid from: %s", value)
+		}
+	}
+
+	if value := get("to"); value != "" {
+		if query.To, err = time.Parse(time.RFC3339, value); err != nil {
+			return Query{}, fmt.Errorf("invalid to: %s", value)
+		}
+	}
+
+	if value := get("page"); value != "" {
+		if query.Page, err = strconv.Atoi(value); err != nil || query.Page < 1 {
+			return Query{}, fmt.Errorf("invalid page: %s", value)
+		}
+	}
+
+	if value := get("pageSize"); value != "" {
+		if query.PageSize, err = strconv.Atoi(value); err != nil || query.PageSize < 1 || query.PageSize > maxPageSize {
+			return Query{}, fmt.Errorf("invalid pageSize: %s", value)
+		}
+	}
+
+	return query, nil
+}
+
+func (q Query) offset() int {
+	return (q.Page - 1) * q.PageSize
+}
+
+func (q Query) inRange(t time.Time) bool {
+	if !q.From.IsZero() && t.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && t.After(q.To) {
+		return false
+	}
+	return true
+}
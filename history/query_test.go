@@ -0,0 +1,59 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	from := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    Query
+		wantErr bool
+	}{
+		{"defaults", nil, Query{Page: 1, PageSize: defaultPageSize}, false},
+		{"all", map[string]string{"q": "joined", "from": "2024-05-01T10:00:00Z", "page": "3", "pageSize": "10"}, Query{Text: "joined", From: from, Page: 3, PageSize: 10}, false},
+		{"invalid from", map[string]string{"from": "yesterday"}, Query{}, true},
+		{"invalid to", map[string]string{"to": "2024-05-01"}, Query{}, true},
+		{"page zero", map[string]string{"page": "0"}, Query{}, true},
+		{"page not a number", map[string]string{"page": "two"}, Query{}, true},
+		{"page size over the limit", map[string]string{"pageSize": "501"}, Query{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseQuery(func(key string) string { return test.params[key] })
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseQuery error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Fatalf("ParseQuery = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryInRange(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	query := Query{From: base, To: base.Add(time.Hour)}
+
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{base.Add(-time.Second), false},
+		{base, true},
+		{base.Add(30 * time.Minute), true},
+		{base.Add(time.Hour), true},
+		{base.Add(time.Hour + time.Second), false},
+	}
+
+	for _, test := range tests {
+		if got := query.inRange(test.t); got != test.want {
+			t.Errorf("inRange(%v) = %v, want %v", test.t, got, test.want)
+		}
+	}
+}
@@ -2,11 +2,13 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/mooncorn/gshub-server-api/config"
 )
@@ -58,27 +60,112 @@ type StartupPayload struct {
 	Cycles         uint                            `json:"cycles"`
 	ServiceConfigs map[string]ServiceConfiguration `json:"serviceConfigs"`
 	Services       []Service                       `json:"services"`
+	// Idempotency keys of the cycle reports the main api has recorded
+	AcknowledgedReports []string     `json:"acknowledgedReports"`
+	Pricing             CyclePricing `json:"pricing"`
+	// Where the backups of the instance are kept, the local disk when empty
+	BackupStorage BackupStorage `json:"backupStorage"`
 }
 
+const (
+	BackupStorageLocal = "local"
+	BackupStorageS3    = "s3"
+	BackupStorageSftp  = "sftp"
+)
+
+// Target the backup archives are uploaded to
+type BackupStorage struct {
+	// One of local, s3 or sftp
+	Driver string `json:"driver"`
+	// Directory of the local driver, the backup directory of the instance when empty
+	Path string            `json:"path"`
+	S3   S3BackupStorage   `json:"s3"`
+	Sftp SftpBackupStorage `json:"sftp"`
+}
+
+// Bucket of an S3-compatible object storage
+type S3BackupStorage struct {
+	// Host and optional port, without the scheme
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	UseSSL    bool   `json:"useSsl"`
+	// Prepended to the names of the archives
+	Prefix string `json:"prefix"`
+}
+
+// Directory on an SFTP server
+type SftpBackupStorage struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	User string `json:"user"`
+	// Either the password or the PEM encoded private key is used to log in
+	Password   string `json:"password"`
+	PrivateKey string `json:"privateKey"`
+	// Public key of the server in authorized_keys format, the connection is refused when it does not match
+	HostKey string `json:"hostKey"`
+	Dir     string `json:"dir"`
+}
+
+// Cycles burned per second under the plan of the instance
+type CyclePricing struct {
+	RunningRate *float64 `json:"runningRate"`
+	StoppedRate *float64 `json:"stoppedRate"`
+}
+
+// Burned cycles report identified by a stable idempotency key
+type CycleReport struct {
+	IdempotencyKey string `json:"idempotencyKey"`
+	Amount         uint   `json:"amount"`
+	// Wall-clock seconds the cycles were burned over
+	Seconds uint `json:"seconds"`
+}
+
+// Cycles purchased while the instance is running
+type TopUp struct {
+	ID     string `json:"id"`
+	Cycles uint   `json:"cycles"`
+}
+
+type ShutdownResponse struct {
+	AcknowledgedKey string `json:"acknowledgedKey"`
+}
+
+// Timeout of a single request attempt
+const requestTimeout = 10 * time.Second
+
 type ApiClient struct {
-	baseUrl    string
-	instanceId string
-	httpClient *http.Client
+	baseUrl        string
+	instanceId     string
+	httpClient     *http.Client
+	StartupPolicy  RetryPolicy
+	ShutdownPolicy RetryPolicy
+	DefaultPolicy  RetryPolicy
 }
 
 func NewClient() *ApiClient {
 	return &ApiClient{
-		baseUrl:    os.Getenv("INTERNAL_API_URL"),
-		instanceId: config.Env.InstanceId,
-		httpClient: &http.Client{},
+		baseUrl:        os.Getenv("INTERNAL_API_URL"),
+		instanceId:     config.Env.InstanceId,
+		httpClient:     &http.Client{Timeout: requestTimeout},
+		StartupPolicy:  StartupRetryPolicy,
+		ShutdownPolicy: ShutdownRetryPolicy,
+		DefaultPolicy:  DefaultRetryPolicy,
 	}
 }
 
-// Gets initialization data for this instance and posts failed burned cycles
-func (c *ApiClient) PostStartup(failedBurnedCyclesTotalAmount uint) (*StartupPayload, error) {
+// Gets initialization data for this instance and posts failed burned cycles.
+// Reports are resent until they are acknowledged, so they are only sent keyed for the main api to bill each key once.
+// Retries until the context deadline is reached.
+func (c *ApiClient) PostStartup(ctx context.Context, failedReports []CycleReport) (*StartupPayload, error) {
 	url := fmt.Sprintf("%s/startup/%s", c.baseUrl, c.instanceId)
-	payload := map[string]interface{}{"failedBurnedCyclesTotalAmount": failedBurnedCyclesTotalAmount, "publicIp": ""}
-	response, err := c.sendRequest("POST", url, payload)
+	payload := map[string]interface{}{
+		"failedBurnedCycles": failedReports,
+		"publicIp":           "",
+	}
+	response, err := c.sendRequest(ctx, c.StartupPolicy, "POST", url, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -90,34 +177,107 @@ func (c *ApiClient) PostStartup(failedBurnedCyclesTotalAmount uint) (*StartupPay
 	return &result, nil
 }
 
-// Posts the number of burned cycles
-func (c *ApiClient) PostShutdown(burnedCycles uint) error {
+// Posts the number of burned cycles.
+// Retries within the shutdown grace window given by the context.
+// The report only counts as delivered when the main api acknowledges its idempotency key.
+func (c *ApiClient) PostShutdown(ctx context.Context, report CycleReport) error {
 	url := fmt.Sprintf("%s/shutdown/%s", c.baseUrl, c.instanceId)
-	payload := map[string]uint{"burnedCyclesAmount": burnedCycles}
-	if _, err := c.sendRequest("POST", url, payload); err != nil {
+	payload := map[string]interface{}{
+		"burnedCyclesAmount": report.Amount,
+		"uptimeSeconds":      report.Seconds,
+		"idempotencyKey":     report.IdempotencyKey,
+	}
+	response, err := c.sendRequest(ctx, c.ShutdownPolicy, "POST", url, payload)
+	if err != nil {
 		return err
 	}
 
+	var result ShutdownResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if result.AcknowledgedKey != report.IdempotencyKey {
+		return fmt.Errorf("report %s was not acknowledged", report.IdempotencyKey)
+	}
+
 	return nil
 }
 
-// sendRequest is a helper method to send HTTP requests
-func (c *ApiClient) sendRequest(method, url string, payload interface{}) ([]byte, error) {
-	var reqBody io.Reader
+// Notifies the main api that the time left reached an alert threshold, given in seconds
+func (c *ApiClient) PostLowCycles(ctx context.Context, remainingCycles uint, threshold uint) error {
+	url := fmt.Sprintf("%s/alerts/low-cycles/%s", c.baseUrl, c.instanceId)
+	payload := map[string]uint{"remainingCycles": remainingCycles, "threshold": threshold}
+	if _, err := c.sendRequest(ctx, c.DefaultPolicy, "POST", url, payload); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Gets the cycle top-ups purchased since this instance started
+func (c *ApiClient) GetTopUps(ctx context.Context) ([]TopUp, error) {
+	url := fmt.Sprintf("%s/topups/%s", c.baseUrl, c.instanceId)
+	response, err := c.sendRequest(ctx, c.DefaultPolicy, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []TopUp
+	if err := json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return result, nil
+}
+
+// Reports an action taken because the game server had no players
+func (c *ApiClient) PostIdleAction(ctx context.Context, action string, idleSeconds uint) error {
+	url := fmt.Sprintf("%s/idle/%s", c.baseUrl, c.instanceId)
+	payload := map[string]interface{}{"action": action, "idleSeconds": idleSeconds}
+	if _, err := c.sendRequest(ctx, c.DefaultPolicy, "POST", url, payload); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sendRequest is a helper method to send HTTP requests, retrying failed attempts according to the policy
+func (c *ApiClient) sendRequest(ctx context.Context, policy RetryPolicy, method, url string, payload interface{}) ([]byte, error) {
+	var jsonPayload []byte
 	if payload != nil {
-		jsonPayload, err := json.Marshal(payload)
+		var err error
+		jsonPayload, err = json.Marshal(payload)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal payload: %v", err)
 		}
-		reqBody = bytes.NewBuffer(jsonPayload)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	var body []byte
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		body, err = c.doRequest(ctx, method, url, jsonPayload)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// doRequest sends a single HTTP request attempt
+func (c *ApiClient) doRequest(ctx context.Context, method, url string, jsonPayload []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if jsonPayload != nil {
+		reqBody = bytes.NewReader(jsonPayload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	if payload != nil {
+	if jsonPayload != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
@@ -133,7 +293,7 @@ func (c *ApiClient) sendRequest(method, url string, payload interface{}) ([]byte
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, body)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: body}
 	}
 
 	return body, nil
@@ -0,0 +1,13 @@
+package internal
+
+import (
+	"time"
+)
+
+// Top-up whose cycles were added to the budget.
+// It is kept so a top-up delivered again after a restart is not credited twice.
+type AppliedTopUp struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Cycles    uint      `json:"cycles"`
+}
@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"time"
+)
+
+const (
+	BackupTriggerManual    = "manual"
+	BackupTriggerScheduled = "scheduled"
+)
+
+// Compressed archive of the game files
+type Backup struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+	// File name of the archive
+	Name string `gorm:"uniqueIndex" json:"name"`
+	Size int64  `json:"size"`
+	// Hex encoded sha256 of the archive
+	Checksum string `json:"checksum"`
+	Trigger  string `json:"trigger"`
+	// Driver of the storage the archive is kept in
+	Storage string `json:"storage"`
+	// Whether the archive reached its storage, until then it is only kept on the local disk
+	Uploaded bool `json:"uploaded"`
+}
@@ -0,0 +1,20 @@
+package internal
+
+import (
+	"time"
+)
+
+// User ID the commands of scheduled jobs are recorded with, users start at 1
+const SchedulerUserID uint = 0
+
+// Game command run by a user through the api, or by a scheduled job
+type CommandRecord struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+	UserID    uint      `gorm:"index" json:"userId"`
+	Command   string    `json:"command"`
+	Output    string    `json:"output"`
+	ExitCode  int       `json:"exitCode"`
+	// Why the command did not run, empty when it ran
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"time"
+)
+
+// Running burned cycles counter of a single boot session.
+// A checkpoint that survives until the next startup means the previous session did not exit cleanly.
+type CycleCheckpoint struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	SessionID string    `gorm:"uniqueIndex" json:"sessionId"`
+	Amount    uint      `json:"amount"`
+	Seconds   uint      `json:"seconds"`
+}
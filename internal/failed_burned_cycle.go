@@ -7,9 +7,14 @@ import (
 )
 
 type FailedBurnedCycle struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	CreatedAt time.Time      `json:"createdAt"`
-	UpdatedAt time.Time      `json:"updatedAt"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt,omitempty"`
-	Amount    uint           `json:"amount"`
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"deletedAt,omitempty"`
+	SessionID      string         `gorm:"uniqueIndex" json:"sessionId"`
+	IdempotencyKey string         `gorm:"uniqueIndex" json:"idempotencyKey"`
+	Amount         uint           `json:"amount"`
+	Seconds        uint           `json:"seconds"`
+	// Set once the main api confirmed it received the report with this idempotency key
+	AcknowledgedAt *time.Time `gorm:"index" json:"acknowledgedAt,omitempty"`
 }
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy describes how failed requests to the main api are retried
+type RetryPolicy struct {
+	// Maximum number of attempts, 0 retries until the context is done
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Fraction of the delay that is randomized, between 0 and 1
+	Jitter float64
+	// Status codes that are worth retrying, network errors are always retried
+	RetryableStatusCodes []int
+}
+
+var DefaultRetryableStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Retries until the startup deadline so a slow main api does not shut the instance down
+var StartupRetryPolicy = RetryPolicy{
+	MaxAttempts:          0,
+	BaseDelay:            500 * time.Millisecond,
+	MaxDelay:             30 * time.Second,
+	Jitter:               0.5,
+	RetryableStatusCodes: DefaultRetryableStatusCodes,
+}
+
+// Retries quickly to fit within the shutdown grace window
+var ShutdownRetryPolicy = RetryPolicy{
+	MaxAttempts:          5,
+	BaseDelay:            200 * time.Millisecond,
+	MaxDelay:             2 * time.Second,
+	Jitter:               0.2,
+	RetryableStatusCodes: DefaultRetryableStatusCodes,
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:          3,
+	BaseDelay:            500 * time.Millisecond,
+	MaxDelay:             5 * time.Second,
+	Jitter:               0.5,
+	RetryableStatusCodes: DefaultRetryableStatusCodes,
+}
+
+// StatusError is returned when the main api responds with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Returns the delay before the given retry, doubling on every attempt up to the max delay
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		spread := float64(delay) * p.Jitter
+		delay = time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
+	}
+
+	return delay
+}
+
+func (p RetryPolicy) isRetryable(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+
+	for _, code := range p.RetryableStatusCodes {
+		if statusErr.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Calls fn until it succeeds, the error is not retryable, the attempts run out or the context is done
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+
+		if !p.isRetryable(err) {
+			return err
+		}
+
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+
+	for _, test := range tests {
+		if got := policy.Backoff(test.attempt); got != test.want {
+			t.Errorf("Backoff(%d) = %v, want %v", test.attempt, got, test.want)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.5}
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 150 * time.Millisecond},
+		{3, 200 * time.Millisecond, 600 * time.Millisecond},
+		// the jitter is spread around the capped delay
+		{100, 500 * time.Millisecond, 1500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		varied := false
+		first := policy.Backoff(test.attempt)
+		for i := 0; i < 100; i++ {
+			got := policy.Backoff(test.attempt)
+			if got < test.min || got > test.max {
+				t.Fatalf("Backoff(%d) = %v, want between %v and %v", test.attempt, got, test.min, test.max)
+			}
+			varied = varied || got != first
+		}
+		if !varied {
+			t.Errorf("Backoff(%d) always returned %v, want a randomized delay", test.attempt, first)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	policy := RetryPolicy{RetryableStatusCodes: DefaultRetryableStatusCodes}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", errors.New("connection refused"), true},
+		{"service unavailable", &StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"too many requests", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"wrapped status", errors.Join(errors.New("request"), &StatusError{StatusCode: http.StatusBadGateway}), true},
+		{"bad request", &StatusError{StatusCode: http.StatusBadRequest}, false},
+		{"unauthorized", &StatusError{StatusCode: http.StatusUnauthorized}, false},
+		{"not found", &StatusError{StatusCode: http.StatusNotFound}, false},
+	}
+
+	for _, test := range tests {
+		if got := policy.isRetryable(test.err); got != test.want {
+			t.Errorf("isRetryable(%s) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, RetryableStatusCodes: DefaultRetryableStatusCodes}
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable}
+	badRequest := &StatusError{StatusCode: http.StatusBadRequest}
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"succeeds at once", []error{nil}, 1, nil},
+		{"succeeds after retries", []error{unavailable, unavailable, nil}, 3, nil},
+		{"gives up after max attempts", []error{unavailable, unavailable, unavailable, nil}, 3, unavailable},
+		{"does not retry a bad request", []error{badRequest, nil}, 1, badRequest},
+	}
+
+	for _, test := range tests {
+		attempts := 0
+		err := policy.Do(context.Background(), func(ctx context.Context) error {
+			err := test.errs[attempts]
+			attempts++
+			return err
+		})
+
+		if attempts != test.wantAttempts {
+			t.Errorf("%s: made %d attempts, want %d", test.name, attempts, test.wantAttempts)
+		}
+		if (test.wantErr == nil) != (err == nil) || !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: Do error = %v, want %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+	// retries forever with long waits
+	policy := RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour}
+	failure := errors.New("connection refused")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- policy.Do(ctx, func(ctx context.Context) error {
+			attempts++
+			return failure
+		})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, failure) {
+			t.Fatalf("Do error = %v, want the last error", err)
+		}
+		if attempts != 1 {
+			t.Fatalf("made %d attempts, want 1", attempts)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Do kept waiting after the context was canceled")
+	}
+}
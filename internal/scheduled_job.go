@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"time"
+)
+
+const (
+	JobActionStart   = "start"
+	JobActionStop    = "stop"
+	JobActionRestart = "restart"
+	JobActionCommand = "command"
+	JobActionBackup  = "backup"
+
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// Action run on a cron schedule
+type ScheduledJob struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name"`
+	// Five field cron expression or a descriptor such as @daily, in the local time of the instance unless it starts with CRON_TZ=
+	Schedule string `json:"schedule"`
+	// One of start, stop, restart, command or backup
+	Action string `json:"action"`
+	// Game command run by the command action
+	Command string `json:"command,omitempty"`
+	Enabled bool   `json:"enabled"`
+
+	// Outcome of the last run
+	LastRunAt  *time.Time `json:"lastRunAt"`
+	LastStatus string     `json:"lastStatus,omitempty"`
+	LastOutput string     `json:"lastOutput,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+}
@@ -16,8 +16,12 @@ import (
 	coreMiddlewares "github.com/mooncorn/gshub-core/middlewares"
 	"github.com/mooncorn/gshub-server-api/app"
 	"github.com/mooncorn/gshub-server-api/config"
+	"github.com/mooncorn/gshub-server-api/cycles"
+	"github.com/mooncorn/gshub-server-api/events"
 	"github.com/mooncorn/gshub-server-api/handlers"
+	"github.com/mooncorn/gshub-server-api/internal"
 	"github.com/mooncorn/gshub-server-api/middlewares"
+	"github.com/mooncorn/gshub-server-api/service"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -30,7 +34,34 @@ func main() {
 	// Get initialization data from main api
 	appCtx := app.NewContext(gormDB)
 
-	go monitorUptime(appCtx)
+	appCtx.Checkpointer.Start()
+	appCtx.TopUpPoller.Start()
+	appCtx.IdleMonitor.Start()
+
+	if err := appCtx.ConsoleArchiver.Start(); err != nil {
+		log.Printf("Failed to start console archive: %v", err)
+	}
+
+	// the burn rate follows the game as soon as it becomes ready or crashes
+	stateChanged := make(chan struct{}, 1)
+	appCtx.EventEngine.OnEvent(func(event events.Event) {
+		if event.Type == service.EventServerReady || event.Type == service.EventCrashed {
+			select {
+			case stateChanged <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	appCtx.EventWebhooks.Start()
+	appCtx.EventEngine.Start()
+	appCtx.Backups.Start()
+	if err := appCtx.Scheduler.Start(); err != nil {
+		log.Printf("Failed to start the scheduler: %v", err)
+	}
+
+	exhausted := make(chan struct{})
+	go monitorUptime(appCtx, stateChanged, exhausted)
 
 	if strings.ToLower(config.Env.AppEnv) == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -45,19 +76,62 @@ func main() {
 		AllowHeaders:  []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
 	}))
 
-	r.Use(coreMiddlewares.CheckUser)
-	r.Use(coreMiddlewares.RequireUser)
-	r.Use(middlewares.CheckOwnership(appCtx))
+	// Routes called by the main api
+	internalRoutes := r.Group("/internal")
+	internalRoutes.Use(middlewares.RequireInternalKey())
+
+	internalRoutes.POST("/cycles/topup", appCtx.HandlerWrapper(handlers.PostTopUp))
 
-	r.GET("/state", appCtx.HandlerWrapper(handlers.GetState))
-	r.GET("/console", appCtx.HandlerWrapper(handlers.GetConsole))
-	r.POST("/run", appCtx.HandlerWrapper(handlers.RunCommand))
-	r.GET("/env", appCtx.HandlerWrapper(handlers.GetEnv))
+	// Routes called by the owner of the instance
+	ownerRoutes := r.Group("/")
+	ownerRoutes.Use(coreMiddlewares.CheckUser)
+	ownerRoutes.Use(coreMiddlewares.RequireUser)
+	ownerRoutes.Use(middlewares.CheckOwnership(appCtx))
 
-	r.POST("/start", appCtx.HandlerWrapper(handlers.StartServer))
-	r.POST("/stop", appCtx.HandlerWrapper(handlers.StopServer))
-	r.POST("/create", appCtx.HandlerWrapper(handlers.CreateServer))
-	r.DELETE("/remove", appCtx.HandlerWrapper(handlers.DeleteServer))
+	ownerRoutes.GET("/state", appCtx.HandlerWrapper(handlers.GetState))
+	ownerRoutes.GET("/console", appCtx.HandlerWrapper(handlers.GetConsole))
+	ownerRoutes.GET("/console/stream", appCtx.HandlerWrapper(handlers.StreamConsole))
+	ownerRoutes.GET("/console/attach", appCtx.HandlerWrapper(handlers.AttachConsole))
+	ownerRoutes.POST("/run", appCtx.HandlerWrapper(handlers.RunCommand))
+	ownerRoutes.GET("/env", appCtx.HandlerWrapper(handlers.GetEnv))
+	ownerRoutes.GET("/events", appCtx.HandlerWrapper(handlers.GetEventStatus))
+	ownerRoutes.GET("/events/stream", appCtx.HandlerWrapper(handlers.StreamEvents))
+	ownerRoutes.GET("/history/commands", appCtx.HandlerWrapper(handlers.GetCommandHistory))
+	ownerRoutes.GET("/history/console", appCtx.HandlerWrapper(handlers.GetConsoleHistory))
+	ownerRoutes.GET("/backups", appCtx.HandlerWrapper(handlers.GetBackups))
+	ownerRoutes.POST("/backups", appCtx.HandlerWrapper(handlers.CreateBackup))
+	ownerRoutes.GET("/backups/:id/download", appCtx.HandlerWrapper(handlers.DownloadBackup))
+	ownerRoutes.DELETE("/backups/:id", appCtx.HandlerWrapper(handlers.DeleteBackup))
+	ownerRoutes.POST("/backups/:id/restore", appCtx.HandlerWrapper(handlers.RestoreBackup))
+	ownerRoutes.GET("/files", appCtx.HandlerWrapper(handlers.ListFiles))
+	ownerRoutes.GET("/files/content", appCtx.HandlerWrapper(handlers.ReadFile))
+	ownerRoutes.PUT("/files/content", appCtx.HandlerWrapper(handlers.WriteFile))
+	ownerRoutes.POST("/files/upload", appCtx.HandlerWrapper(handlers.UploadFile))
+	ownerRoutes.POST("/files/mkdir", appCtx.HandlerWrapper(handlers.MakeDir))
+	ownerRoutes.GET("/files/download", appCtx.HandlerWrapper(handlers.DownloadFile))
+	ownerRoutes.POST("/files/rename", appCtx.HandlerWrapper(handlers.RenameFile))
+	ownerRoutes.DELETE("/files", appCtx.HandlerWrapper(handlers.DeleteFile))
+	ownerRoutes.GET("/mods", appCtx.HandlerWrapper(handlers.GetMods))
+	ownerRoutes.POST("/mods/upload", appCtx.HandlerWrapper(handlers.UploadMod))
+	ownerRoutes.POST("/mods/url", appCtx.HandlerWrapper(handlers.InstallModFromUrl))
+	ownerRoutes.POST("/mods/:name/enable", appCtx.HandlerWrapper(handlers.EnableMod))
+	ownerRoutes.POST("/mods/:name/disable", appCtx.HandlerWrapper(handlers.DisableMod))
+	ownerRoutes.DELETE("/mods/:name", appCtx.HandlerWrapper(handlers.RemoveMod))
+	ownerRoutes.GET("/jobs", appCtx.HandlerWrapper(handlers.GetJobs))
+	ownerRoutes.POST("/jobs", appCtx.HandlerWrapper(handlers.CreateJob))
+	ownerRoutes.GET("/jobs/:id", appCtx.HandlerWrapper(handlers.GetJob))
+	ownerRoutes.PATCH("/jobs/:id", appCtx.HandlerWrapper(handlers.UpdateJob))
+	ownerRoutes.DELETE("/jobs/:id", appCtx.HandlerWrapper(handlers.DeleteJob))
+	ownerRoutes.POST("/jobs/:id/run", appCtx.HandlerWrapper(handlers.RunJob))
+	ownerRoutes.GET("/cycles", appCtx.HandlerWrapper(handlers.GetCycles))
+	ownerRoutes.GET("/cycles/stream", appCtx.HandlerWrapper(handlers.StreamCycles))
+
+	ownerRoutes.POST("/start", appCtx.HandlerWrapper(handlers.StartServer))
+	ownerRoutes.POST("/stop", appCtx.HandlerWrapper(handlers.StopServer))
+	ownerRoutes.POST("/restart", appCtx.HandlerWrapper(handlers.RestartServer))
+	ownerRoutes.POST("/create", appCtx.HandlerWrapper(handlers.CreateServer))
+	ownerRoutes.PATCH("/server", appCtx.HandlerWrapper(handlers.UpdateServer))
+	ownerRoutes.DELETE("/remove", appCtx.HandlerWrapper(handlers.DeleteServer))
 
 	server := &http.Server{
 		Addr:    ":" + config.Env.Port,
@@ -75,41 +149,159 @@ func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	// Block until a signal is received
-	<-stop
+	// Block until a signal is received, the cycles run out or the instance is idle.
+	// A signal only restarts the api, the game keeps running unless the cycles are gone.
+	shutdownInstance := false
+	cyclesExhausted := false
+	select {
+	case <-stop:
+	case <-exhausted:
+		shutdownInstance = true
+		cyclesExhausted = true
+	case <-appCtx.IdleMonitor.ShutdownRequested():
+		shutdownInstance = true
+	}
 
 	log.Println("Shutting down gracefully...")
 
-	// Create a context with timeout for the shutdown
-	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Create a context with timeout for the shutdown, stopping the game gets its own share of it
+	shutdownTimeout := 5 * time.Second
+	gameStopTimeout := time.Duration(0)
+	if cyclesExhausted {
+		// leave some room on top of the game's own stop timeout for the save commands
+		gameStopTimeout = appCtx.ServiceController.StopTimeout(context.Background()) + 30*time.Second
+		shutdownTimeout += gameStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
+	// a pending mod restart or a scheduled job must not start the game again
+	appCtx.Mods.Stop()
+	appCtx.Scheduler.Stop()
+	if cyclesExhausted {
+		stopGame(ctx, appCtx, gameStopTimeout)
+	}
+
+	appCtx.IdleMonitor.Stop()
+	appCtx.Backups.Stop()
+	appCtx.ConsoleArchiver.Stop()
+	appCtx.EventEngine.Stop()
+	// undelivered events are dropped rather than holding back the cycle report
+	webhooksCtx, cancelWebhooks := context.WithTimeout(ctx, webhookStopTimeout)
+	appCtx.EventWebhooks.Stop(webhooksCtx)
+	cancelWebhooks()
+	appCtx.TopUpPoller.Stop()
+	appCtx.Checkpointer.Stop()
+
 	// Send burned cycles to main api
-	if err := cleanup(appCtx); err != nil {
+	if err := cleanup(ctx, appCtx); err != nil {
 		log.Fatalf("Cleanup failed: %v", err)
-		// TODO: save burned cycles to local sqlite
 	}
 
-	log.Println("Uptime sent successfully")
+	// Power off the instance once its cycles are gone or it is idle, only a real instance can be shut down
+	if shutdownInstance && strings.ToLower(config.Env.AppEnv) == "production" {
+		if _, err := appCtx.SystemController.Shutdown(); err != nil {
+			log.Printf("Failed to shut down the instance: %v", err)
+		}
+	}
+
 	log.Println("Server exiting")
 }
 
-func cleanup(appCtx *app.Context) error {
-	err := appCtx.CyclesApiClient.PostShutdown(appCtx.BurnedCycles)
-	return err
+// Share of the shutdown the queued webhook events get to be delivered, the cycle report needs the rest
+const webhookStopTimeout = 2 * time.Second
+
+// Saves and stops the game so no progress is lost when the instance goes down
+func stopGame(c context.Context, appCtx *app.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(c, timeout)
+	defer cancel()
+
+	if err := appCtx.ServiceController.GracefulStop(ctx); err != nil {
+		log.Printf("Failed to stop the game gracefully: %v", err)
+		return
+	}
+
+	log.Println("Game stopped")
+}
+
+// Reports burned cycles to the main api, falling back to the local database
+// so they can be sent on the next startup
+func cleanup(ctx context.Context, appCtx *app.Context) error {
+	burnedCycles, seconds := appCtx.CycleMeter.Usage()
+	idempotencyKey := cycles.ReportKey(appCtx.SessionID)
+
+	err := appCtx.CyclesApiClient.PostShutdown(ctx, internal.CycleReport{
+		IdempotencyKey: idempotencyKey,
+		Amount:         burnedCycles,
+		Seconds:        seconds,
+	})
+	if err == nil {
+		log.Println("Uptime sent successfully")
+
+		// the checkpoint is no longer needed once the main api has the burned cycles
+		if err := appCtx.Checkpointer.Clear(appCtx.DB); err != nil {
+			log.Printf("Failed to clear cycle checkpoint: %v", err)
+		}
+		return nil
+	}
+
+	log.Printf("Failed to send burned cycles: %v", err)
+
+	// persisting the burned cycles and removing the checkpoint must happen together,
+	// otherwise the same cycles would be reported twice on the next startup
+	err = appCtx.DB.Transaction(func(tx *gorm.DB) error {
+		failed := internal.FailedBurnedCycle{
+			SessionID:      appCtx.SessionID,
+			IdempotencyKey: idempotencyKey,
+			Amount:         burnedCycles,
+			Seconds:        seconds,
+		}
+
+		if err := tx.Create(&failed).Error; err != nil {
+			return err
+		}
+
+		return appCtx.Checkpointer.Clear(tx)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save burned cycles: %v", err)
+	}
+
+	log.Printf("Saved %d burned cycles locally", burnedCycles)
+	return nil
 }
 
-func monitorUptime(appCtx *app.Context) {
-	for {
-		appCtx.BurnedCycles++
+// How often the burn rate is adjusted to the state of the service
+const serviceStateRefreshInterval = 10
 
-		fmt.Printf("Burned cycles: %d/%d\n", appCtx.BurnedCycles, appCtx.InstancePayload.Cycles)
+func monitorUptime(appCtx *app.Context, stateChanged <-chan struct{}, exhausted chan<- struct{}) {
+	running := false
 
-		if appCtx.InstancePayload.Cycles <= appCtx.BurnedCycles {
+	for tick := 0; ; tick++ {
+		select {
+		case <-stateChanged:
+			running = isServiceRunning(appCtx, running)
+		default:
+			if tick%serviceStateRefreshInterval == 0 {
+				running = isServiceRunning(appCtx, running)
+			}
+		}
+
+		burnedCycles := appCtx.CycleMeter.Burn(appCtx.CyclePricing.Rate(running))
+
+		fmt.Printf("Burned cycles: %d/%d\n", burnedCycles, appCtx.CycleMeter.Budget())
+
+		// the cycles do not run out while nothing is burned, the alerts wait until they do
+		remainingCycles := appCtx.CycleMeter.Remaining()
+		if timeLeft := appCtx.CyclePricing.TimeLeft(remainingCycles, running); timeLeft != cycles.NoTimeLimit {
+			for _, threshold := range appCtx.CycleAlerter.Check(timeLeft) {
+				go alertLowCycles(appCtx, remainingCycles, timeLeft, threshold)
+			}
+		}
+
+		if appCtx.CycleMeter.Exhausted() {
 			fmt.Println("Allowed uptime reached. Shutting down...")
-			// appCtx.SystemController.Shutdown() // TODO: execute only in production
-			p, _ := os.FindProcess(os.Getpid())
-			p.Signal(syscall.SIGINT)
+			close(exhausted)
 			return
 		}
 
@@ -117,10 +309,45 @@ func monitorUptime(appCtx *app.Context) {
 	}
 }
 
+// Returns whether the service is running, keeping the last known state when it can not be checked
+func isServiceRunning(appCtx *app.Context, lastKnown bool) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	running, err := appCtx.ServiceController.IsRunning(ctx)
+	if err != nil {
+		log.Printf("Failed to get service state: %v", err)
+		return lastKnown
+	}
+	return running
+}
+
+// Warns the owner through the main api and the players in game that cycles are running out
+func alertLowCycles(appCtx *app.Context, remainingCycles uint, timeLeft time.Duration, threshold uint) {
+	fmt.Printf("Low cycles: %d remaining\n", remainingCycles)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := appCtx.CyclesApiClient.PostLowCycles(ctx, remainingCycles, threshold); err != nil {
+		log.Printf("Failed to send low cycles alert: %v", err)
+	}
+
+	message := fmt.Sprintf("Server shutting down in %s: out of cycles", cycles.FormatRemaining(timeLeft))
+	if err := appCtx.ServiceController.Broadcast(ctx, message); err != nil {
+		log.Printf("Failed to broadcast low cycles warning: %v", err)
+	}
+}
+
 func initializeDatabase() *gorm.DB {
 	db, err := gorm.Open(sqlite.Open("local.db"), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
+
+	if err := db.AutoMigrate(&internal.FailedBurnedCycle{}, &internal.CycleCheckpoint{}, &internal.AppliedTopUp{}, &internal.CommandRecord{}, &internal.Backup{}, &internal.ScheduledJob{}); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+
 	return db
 }
@@ -20,7 +20,7 @@ func CheckOwnership(appCtx *app.Context) func(c *gin.Context) {
 			return
 		}
 
-		if uint(userID64) != appCtx.ServiceData.OwnerID {
+		if uint(userID64) != appCtx.StartupPayload.OwnerID {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Access unauthorized"})
 			c.Abort()
 			return
@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mooncorn/gshub-server-api/config"
+)
+
+// Allows only the main api, which authenticates with the shared internal api key
+func RequireInternalKey() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-Internal-Key")
+
+		if config.Env.InternalApiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(config.Env.InternalApiKey)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Access unauthorized"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
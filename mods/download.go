@@ -0,0 +1,86 @@
+package mods
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+)
+
+const downloadTimeout = 10 * time.Minute
+
+// Returned when a mod url is not an http or https url, or points at a private address
+var ErrInvalidModUrl = errors.New("invalid mod url")
+
+// Downloads mods from public addresses only, so a url can not reach the instance metadata or other internal services
+type downloader struct {
+	httpClient *http.Client
+}
+
+func newDownloader() *downloader {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+				return fmt.Errorf("%w: %s is not a public address", ErrInvalidModUrl, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &downloader{
+		httpClient: &http.Client{Transport: transport, Timeout: downloadTimeout},
+	}
+}
+
+// Starts the download and gets the file name from the url, the body is cut after the limit
+func (d *downloader) download(c context.Context, rawUrl string, limit int64) (io.ReadCloser, string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", ErrInvalidModUrl
+	}
+
+	req, err := http.NewRequestWithContext(c, "GET", u.String(), nil)
+	if err != nil {
+		return nil, "", ErrInvalidModUrl
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidModUrl) {
+			return nil, "", ErrInvalidModUrl
+		}
+		return nil, "", fmt.Errorf("failed to download mod: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("failed to download mod: %s", resp.Status)
+	}
+
+	// redirects may lead to a url with the actual file name
+	return limitedBody{io.LimitReader(resp.Body, limit+1), resp.Body}, path.Base(resp.Request.URL.Path), nil
+}
+
+func isPublic(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,93 @@
+package mods
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInstallFromUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			http.Redirect(w, r, "/files/plugin.jar", http.StatusFound)
+		case "/files/plugin.jar":
+			w.Write([]byte("jar"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		mod      string
+		checksum string
+		// downloads through the test server, which the manager would refuse as it is on loopback
+		allowLocal bool
+		wantName   string
+		wantErr    error
+	}{
+		{"missing checksum", server.URL + "/files/plugin.jar", "", "", true, "", ErrChecksumRequired},
+		{"checksum mismatch", server.URL + "/files/plugin.jar", "", checksum("other"), true, "", ErrChecksumMismatch},
+		{"name from redirect", server.URL + "/download", "", checksum("jar"), true, "plugin.jar", nil},
+		{"given name", server.URL + "/download", "renamed.jar", checksum("jar"), true, "renamed.jar", nil},
+		{"loopback", server.URL + "/files/plugin.jar", "", checksum("jar"), false, "", ErrInvalidModUrl},
+		{"private address", "http://10.0.0.1/plugin.jar", "", checksum("jar"), false, "", ErrInvalidModUrl},
+		{"link local address", "http://169.254.169.254/plugin.jar", "", checksum("jar"), false, "", ErrInvalidModUrl},
+		{"file url", "file:///etc/passwd", "plugin.jar", checksum("jar"), false, "", ErrInvalidModUrl},
+		{"ftp url", "ftp://example.com/plugin.jar", "", checksum("jar"), false, "", ErrInvalidModUrl},
+		{"no host", "http:///plugin.jar", "", checksum("jar"), false, "", ErrInvalidModUrl},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, volume := newTestManager(t, time.Hour)
+			if test.allowLocal {
+				m.downloader = &downloader{httpClient: server.Client()}
+			}
+
+			mod, err := m.InstallFromUrl(context.Background(), test.mod, test.url, test.checksum)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("InstallFromUrl error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				return
+			}
+			if mod.Name != test.wantName || !volume.exists("plugins/"+test.wantName) {
+				t.Fatalf("InstallFromUrl = %+v, want %s installed", mod, test.wantName)
+			}
+		})
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		if got := isPublic(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("isPublic(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
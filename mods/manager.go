@@ -0,0 +1,343 @@
+package mods
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/files"
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+const (
+	// How long after the last change the server restarts, changes made in the meantime share the restart
+	DefaultRestartDelay = time.Minute
+
+	restartTimeout = 5 * time.Minute
+)
+
+var (
+	ErrModNotFound    = errors.New("mod not found")
+	ErrInvalidModName = errors.New("invalid mod file name")
+	// Returned when an installed file does not match the checksum given with it
+	ErrChecksumMismatch = errors.New("mod does not match its checksum")
+	// Returned when a mod is installed from a url without a checksum
+	ErrChecksumRequired = errors.New("a checksum is required to install a mod from a url")
+)
+
+// Target is the part of the service controller the mod manager relies on
+type Target interface {
+	ModLayout(c context.Context) (service.ModLayout, error)
+	IsRunning(c context.Context) (bool, error)
+	Broadcast(c context.Context, message string) error
+	RestartService(c context.Context) error
+}
+
+// Mod or plugin file of the game
+type Mod struct {
+	Name    string    `json:"name"`
+	Enabled bool      `json:"enabled"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Manager installs, enables, disables and removes the mods of games whose strategy has a mod layout.
+// The game loads mods when it starts, so changes restart it once the restart delay passes without further changes.
+type Manager struct {
+	target       Target
+	files        *files.Manager
+	downloader   *downloader
+	restartDelay time.Duration
+
+	mu           sync.Mutex
+	restartTimer *time.Timer
+	restartAt    time.Time
+	// tells a timer that fired late from the one that replaced it
+	restartGeneration int
+}
+
+func NewManager(target Target, files *files.Manager, restartDelay time.Duration) *Manager {
+	return &Manager{
+		target:       target,
+		files:        files,
+		downloader:   newDownloader(),
+		restartDelay: restartDelay,
+	}
+}
+
+// Cancels the pending restart
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.restartTimer != nil {
+		m.restartTimer.Stop()
+		m.restartTimer = nil
+	}
+}
+
+// Gets the directories mods are kept in
+func (m *Manager) Layout(c context.Context) (service.ModLayout, error) {
+	return m.target.ModLayout(c)
+}
+
+// Gets when the server restarts to apply the mod changes, nil when no restart is pending
+func (m *Manager) RestartAt() *time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.restartTimer == nil {
+		return nil
+	}
+	restartAt := m.restartAt
+	return &restartAt
+}
+
+// Lists the enabled and the disabled mods
+func (m *Manager) List(c context.Context) ([]Mod, error) {
+	layout, err := m.target.ModLayout(c)
+	if err != nil {
+		return nil, err
+	}
+
+	mods := []Mod{}
+	for _, dir := range []struct {
+		path    string
+		enabled bool
+	}{{layout.Dir, true}, {layout.DisabledDir, false}} {
+		entries, err := m.files.List(c, dir.path)
+		if errors.Is(err, files.ErrFileNotFound) {
+			// no mod was installed or disabled yet
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.Dir || !hasExtension(entry.Name, layout.Extension) {
+				continue
+			}
+			mods = append(mods, Mod{Name: entry.Name, Enabled: dir.enabled, Size: entry.Size, ModTime: entry.ModTime})
+		}
+	}
+	return mods, nil
+}
+
+// Installs the mod read from r as enabled, replacing one with the same name.
+// When a checksum is given, the hex encoded sha256 of the file must match it.
+func (m *Manager) Install(c context.Context, name string, r io.Reader, checksum string) (Mod, error) {
+	layout, err := m.target.ModLayout(c)
+	if err != nil {
+		return Mod{}, err
+	}
+	if !validModName(name, layout) {
+		return Mod{}, ErrInvalidModName
+	}
+
+	// the file is checked before it gets near the game
+	staged, err := m.stage(r, checksum)
+	if err != nil {
+		return Mod{}, err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	if err := m.files.MakeDir(c, layout.Dir); err != nil {
+		return Mod{}, err
+	}
+
+	entry, err := m.files.Upload(c, layout.Dir, name, staged)
+	if err != nil {
+		return Mod{}, err
+	}
+
+	// an older disabled copy would come back when the mod is disabled and enabled again
+	if err := m.files.Delete(c, path.Join(layout.DisabledDir, name)); err != nil && !errors.Is(err, files.ErrFileNotFound) {
+		log.Printf("Failed to remove the disabled copy of %s: %v", name, err)
+	}
+
+	m.scheduleRestart(c)
+	return Mod{Name: entry.Name, Enabled: true, Size: entry.Size, ModTime: entry.ModTime}, nil
+}
+
+// Downloads the mod and installs it, the checksum is required as the file comes from outside
+func (m *Manager) InstallFromUrl(c context.Context, name string, url string, checksum string) (Mod, error) {
+	if checksum == "" {
+		return Mod{}, ErrChecksumRequired
+	}
+
+	body, fileName, err := m.downloader.download(c, url, m.files.MaxUploadSize())
+	if err != nil {
+		return Mod{}, err
+	}
+	defer body.Close()
+
+	if name == "" {
+		name = fileName
+	}
+	return m.Install(c, name, body, checksum)
+}
+
+// Copies the mod to a temporary file and verifies its checksum, the returned file is positioned at its start
+func (m *Manager) stage(r io.Reader, checksum string) (*os.File, error) {
+	f, err := os.CreateTemp("", "mod-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stage mod: %v", err)
+	}
+
+	fail := func(err error) (*os.File, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	limit := m.files.MaxUploadSize()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(r, limit+1))
+	if err != nil {
+		return fail(fmt.Errorf("failed to stage mod: %v", err))
+	}
+	if n > limit {
+		return fail(files.ErrFileTooLarge)
+	}
+
+	if checksum != "" && !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), strings.TrimSpace(checksum)) {
+		return fail(ErrChecksumMismatch)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(fmt.Errorf("failed to stage mod: %v", err))
+	}
+	return f, nil
+}
+
+// Moves the mod into the directory the game loads mods from, or out of it
+func (m *Manager) SetEnabled(c context.Context, name string, enabled bool) error {
+	layout, err := m.target.ModLayout(c)
+	if err != nil {
+		return err
+	}
+	if !validModName(name, layout) {
+		return ErrInvalidModName
+	}
+
+	from, to := layout.DisabledDir, layout.Dir
+	if !enabled {
+		from, to = layout.Dir, layout.DisabledDir
+	}
+
+	if err := m.files.MakeDir(c, to); err != nil {
+		return err
+	}
+
+	err = m.files.Rename(c, path.Join(from, name), path.Join(to, name))
+	if errors.Is(err, files.ErrFileNotFound) {
+		if f, _, openErr := m.files.Open(c, path.Join(to, name)); openErr == nil {
+			// already where it should be
+			f.Close()
+			return nil
+		}
+		return ErrModNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	m.scheduleRestart(c)
+	return nil
+}
+
+// Deletes the mod, whether it is enabled or not
+func (m *Manager) Remove(c context.Context, name string) error {
+	layout, err := m.target.ModLayout(c)
+	if err != nil {
+		return err
+	}
+	if !validModName(name, layout) {
+		return ErrInvalidModName
+	}
+
+	removed := false
+	for _, dir := range []string{layout.Dir, layout.DisabledDir} {
+		err := m.files.Delete(c, path.Join(dir, name))
+		if errors.Is(err, files.ErrFileNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		removed = true
+	}
+
+	if !removed {
+		return ErrModNotFound
+	}
+
+	m.scheduleRestart(c)
+	return nil
+}
+
+// Restarts the server once the delay passes without another change, a stopped server loads the mods when it starts
+func (m *Manager) scheduleRestart(c context.Context) {
+	running, err := m.target.IsRunning(c)
+	if err != nil || !running {
+		return
+	}
+
+	m.mu.Lock()
+	if m.restartTimer != nil {
+		m.restartTimer.Stop()
+	}
+	m.restartGeneration++
+	generation := m.restartGeneration
+	m.restartAt = time.Now().Add(m.restartDelay)
+	m.restartTimer = time.AfterFunc(m.restartDelay, func() { m.restart(generation) })
+	m.mu.Unlock()
+
+	message := fmt.Sprintf("The server restarts in %s to apply mod changes", m.restartDelay)
+	if err := m.target.Broadcast(c, message); err != nil && !errors.Is(err, service.ErrNotSupported) {
+		log.Printf("Failed to announce the mod restart: %v", err)
+	}
+}
+
+func (m *Manager) restart(generation int) {
+	m.mu.Lock()
+	if generation != m.restartGeneration || m.restartTimer == nil {
+		m.mu.Unlock()
+		return
+	}
+	m.restartTimer = nil
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), restartTimeout)
+	defer cancel()
+
+	// the server may have been stopped in the meantime
+	if running, err := m.target.IsRunning(ctx); err != nil || !running {
+		return
+	}
+
+	if err := m.target.RestartService(ctx); err != nil {
+		log.Printf("Failed to restart the server to apply mod changes: %v", err)
+		return
+	}
+	log.Printf("Server restarted to apply mod changes")
+}
+
+func validModName(name string, layout service.ModLayout) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\\x00") && hasExtension(name, layout.Extension)
+}
+
+func hasExtension(name string, extension string) bool {
+	return strings.HasSuffix(strings.ToLower(name), strings.ToLower(extension))
+}
@@ -0,0 +1,292 @@
+package mods
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/files"
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+	"github.com/mooncorn/gshub-server-api/service/servicetest"
+)
+
+const minecraftImage = "itzg/minecraft-server"
+
+// Paper server whose /data volume lives in a temporary directory, it loads plugins from /data/plugins
+type testServer struct {
+	docker *servicetest.FakeDockerClient
+	host   string
+	// times the server was started after it was set up
+	starts atomic.Int32
+}
+
+func newTestManager(t *testing.T, restartDelay time.Duration) (*Manager, *testServer) {
+	t.Helper()
+
+	server := &testServer{docker: servicetest.NewFakeDockerClient(), host: t.TempDir()}
+	data := &service.InstanceData{
+		InstanceID: "instance",
+		StartupPayload: internal.StartupPayload{
+			InstanceMemory: 4096,
+			ServiceConfigs: map[string]internal.ServiceConfiguration{
+				"minecraft": {
+					Name:    "minecraft",
+					Image:   minecraftImage,
+					MinMem:  1024,
+					Volumes: []internal.Volume{{Host: server.host, Destination: "/data"}},
+				},
+			},
+		},
+	}
+	controller := service.NewServiceControllerWithClient(data, server.docker)
+
+	// without an rcon password commands are run with exec, which the fake echoes
+	server.docker.AddImage(minecraftImage)
+	config := service.ContainerConfig{Image: minecraftImage, Env: map[string]string{"TYPE": "PAPER"}}
+	if _, err := server.docker.CreateContainer(context.Background(), service.SERVICE_CONTAINER_ID, config); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(controller, files.NewManager(controller, 1024, 4096), restartDelay)
+	t.Cleanup(m.Stop)
+	return m, server
+}
+
+// Starts the server, later starts are counted
+func (s *testServer) start(t *testing.T) {
+	t.Helper()
+
+	if err := s.docker.StartContainer(context.Background(), service.SERVICE_CONTAINER_ID); err != nil {
+		t.Fatal(err)
+	}
+	s.docker.StartHandler = func(service.Container) error {
+		s.starts.Add(1)
+		return nil
+	}
+}
+
+func (s *testServer) exists(name string) bool {
+	_, err := os.Lstat(filepath.Join(s.host, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestInstall(t *testing.T) {
+	tests := []struct {
+		name     string
+		mod      string
+		content  string
+		checksum string
+		wantErr  error
+	}{
+		{"without checksum", "plugin.jar", "jar", "", nil},
+		{"matching checksum", "plugin.jar", "jar", checksum("jar"), nil},
+		{"uppercase checksum", "plugin.jar", "jar", strings.ToUpper(checksum("jar")), nil},
+		{"checksum mismatch", "plugin.jar", "jar", checksum("other"), ErrChecksumMismatch},
+		{"too large", "plugin.jar", strings.Repeat("a", 4097), "", files.ErrFileTooLarge},
+		{"wrong extension", "plugin.zip", "jar", "", ErrInvalidModName},
+		{"path separator", "../plugin.jar", "jar", "", ErrInvalidModName},
+		{"backslash", "..\\plugin.jar", "jar", "", ErrInvalidModName},
+		{"dot dot", "..", "jar", "", ErrInvalidModName},
+		{"hidden", ".plugin.jar", "jar", "", ErrInvalidModName},
+		{"empty", "", "jar", "", ErrInvalidModName},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, server := newTestManager(t, time.Hour)
+
+			mod, err := m.Install(context.Background(), test.mod, strings.NewReader(test.content), test.checksum)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Install error = %v, want %v", err, test.wantErr)
+			}
+
+			if test.wantErr != nil {
+				// the mod is checked before the plugins directory is made
+				if server.exists("plugins") || server.exists("plugin.jar") {
+					t.Fatal("a rejected mod was written to the volume")
+				}
+				return
+			}
+			if !server.exists("plugins/"+test.mod) || mod.Name != test.mod || !mod.Enabled || mod.Size != int64(len(test.content)) {
+				t.Fatalf("Install = %+v, want the enabled mod in the plugins directory", mod)
+			}
+		})
+	}
+}
+
+func TestInstallReplacesDisabledCopy(t *testing.T) {
+	ctx := context.Background()
+	m, server := newTestManager(t, time.Hour)
+
+	if _, err := m.Install(ctx, "plugin.jar", strings.NewReader("old"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetEnabled(ctx, "plugin.jar", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Install(ctx, "plugin.jar", strings.NewReader("new"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.exists("plugins-disabled/plugin.jar") {
+		t.Fatal("the disabled copy was kept next to the new one")
+	}
+	mods, err := m.List(ctx)
+	if err != nil || len(mods) != 1 || !mods[0].Enabled || mods[0].Size != 3 {
+		t.Fatalf("List = %+v, %v, want only the new enabled mod", mods, err)
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	ctx := context.Background()
+	m, server := newTestManager(t, time.Hour)
+
+	if _, err := m.Install(ctx, "plugin.jar", strings.NewReader("jar"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		enabled bool
+		wantIn  string
+		wantOut string
+	}{
+		{"disable", false, "plugins-disabled", "plugins"},
+		{"disable again", false, "plugins-disabled", "plugins"},
+		{"enable", true, "plugins", "plugins-disabled"},
+		{"enable again", true, "plugins", "plugins-disabled"},
+	}
+
+	for _, step := range steps {
+		if err := m.SetEnabled(ctx, "plugin.jar", step.enabled); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !server.exists(step.wantIn+"/plugin.jar") || server.exists(step.wantOut+"/plugin.jar") {
+			t.Fatalf("%s: mod is not only in %s", step.name, step.wantIn)
+		}
+
+		mods, err := m.List(ctx)
+		if err != nil || len(mods) != 1 || mods[0].Enabled != step.enabled {
+			t.Fatalf("%s: List = %+v, %v, want the mod with enabled %v", step.name, mods, err, step.enabled)
+		}
+	}
+
+	if err := m.SetEnabled(ctx, "missing.jar", false); !errors.Is(err, ErrModNotFound) {
+		t.Fatalf("SetEnabled of an unknown mod error = %v, want ErrModNotFound", err)
+	}
+	if err := m.SetEnabled(ctx, "../plugin.jar", false); !errors.Is(err, ErrInvalidModName) {
+		t.Fatalf("SetEnabled of an invalid name error = %v, want ErrInvalidModName", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ctx := context.Background()
+	m, server := newTestManager(t, time.Hour)
+
+	for _, name := range []string{"enabled.jar", "disabled.jar"} {
+		if _, err := m.Install(ctx, name, strings.NewReader("jar"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetEnabled(ctx, "disabled.jar", false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		wantErr error
+	}{
+		{"enabled.jar", nil},
+		{"disabled.jar", nil},
+		{"enabled.jar", ErrModNotFound},
+		{"unknown.jar", ErrModNotFound},
+		{"../enabled.jar", ErrInvalidModName},
+		{"plugins/..", ErrInvalidModName},
+	}
+
+	for _, test := range tests {
+		if err := m.Remove(ctx, test.name); !errors.Is(err, test.wantErr) {
+			t.Fatalf("Remove(%q) error = %v, want %v", test.name, err, test.wantErr)
+		}
+	}
+
+	if server.exists("plugins/enabled.jar") || server.exists("plugins-disabled/disabled.jar") {
+		t.Fatal("a removed mod is still in the volume")
+	}
+}
+
+func TestRestartIsDebounced(t *testing.T) {
+	ctx := context.Background()
+	delay := 200 * time.Millisecond
+	m, server := newTestManager(t, delay)
+
+	// a stopped server loads the mods when it starts
+	if _, err := m.Install(ctx, "first.jar", strings.NewReader("jar"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if restartAt := m.RestartAt(); restartAt != nil {
+		t.Fatalf("RestartAt = %v with the server stopped, want nil", restartAt)
+	}
+
+	server.start(t)
+
+	if _, err := m.Install(ctx, "second.jar", strings.NewReader("jar"), ""); err != nil {
+		t.Fatal(err)
+	}
+	first := m.RestartAt()
+	if first == nil {
+		t.Fatal("RestartAt = nil after a change, want a pending restart")
+	}
+
+	time.Sleep(delay / 2)
+	if err := m.SetEnabled(ctx, "first.jar", false); err != nil {
+		t.Fatal(err)
+	}
+	second := m.RestartAt()
+	if second == nil || !second.After(*first) {
+		t.Fatalf("RestartAt = %v after another change, want it moved past %v", second, *first)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.RestartAt() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// a late timer of the first change must not restart the server again
+	time.Sleep(delay)
+
+	if starts := server.starts.Load(); starts != 1 {
+		t.Fatalf("server started %d times, want a single restart for both changes", starts)
+	}
+	if restartAt := m.RestartAt(); restartAt != nil {
+		t.Fatalf("RestartAt = %v after the restart, want nil", restartAt)
+	}
+}
+
+func TestStopCancelsRestart(t *testing.T) {
+	delay := 50 * time.Millisecond
+	m, server := newTestManager(t, delay)
+	server.start(t)
+
+	if _, err := m.Install(context.Background(), "plugin.jar", strings.NewReader("jar"), ""); err != nil {
+		t.Fatal(err)
+	}
+	m.Stop()
+	time.Sleep(3 * delay)
+
+	if starts := server.starts.Load(); starts != 0 {
+		t.Fatalf("server started %d times after Stop, want no restart", starts)
+	}
+}
@@ -0,0 +1,180 @@
+package rcon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultTimeout = 10 * time.Second
+
+// Returned when the server rejects the password
+var ErrAuthFailed = errors.New("rcon authentication failed")
+
+// Client runs commands on a game server over the RCON protocol.
+// It connects lazily, reconnects when the connection breaks and is safe for concurrent use.
+type Client struct {
+	mu       sync.Mutex
+	address  string
+	password string
+	timeout  time.Duration
+	conn     net.Conn
+	nextID   int32
+}
+
+func NewClient(address string, password string) *Client {
+	return &Client{
+		address:  address,
+		password: password,
+		timeout:  DefaultTimeout,
+	}
+}
+
+// Runs a command and returns its response, joined back together if the server split it into several packets.
+// The command is sent again only when it never reached the server, so it does not run twice.
+func (c *Client) Execute(command string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the server may have restarted since the last command
+	if c.conn != nil && c.closedByServer() {
+		c.close()
+	}
+
+	response, sent, err := c.execute(command)
+	if err == nil || sent || errors.Is(err, ErrAuthFailed) {
+		return response, err
+	}
+
+	c.close()
+	response, _, err = c.execute(command)
+	return response, err
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.close()
+}
+
+// Runs the command on the current connection, sent tells whether the command may have reached the server
+func (c *Client) execute(command string) (response string, sent bool, err error) {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return "", false, err
+		}
+	}
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	requestID := c.newID()
+	if err := writePacket(c.conn, packet{ID: requestID, Type: typeExecCommand, Body: command}); err != nil {
+		c.close()
+		return "", false, fmt.Errorf("failed to send command: %v", err)
+	}
+
+	// The server answers packets in order, so the response to this empty packet marks the end of a multi-packet response.
+	// Source servers echo it back empty, Minecraft answers it with an unknown request message.
+	terminatorID := c.newID()
+	if err := writePacket(c.conn, packet{ID: terminatorID, Type: typeResponseValue}); err != nil {
+		c.close()
+		return "", true, fmt.Errorf("failed to send command: %v", err)
+	}
+
+	var body strings.Builder
+	for {
+		p, err := readPacket(c.conn)
+		if err != nil {
+			c.close()
+			return "", true, fmt.Errorf("failed to read response: %v", err)
+		}
+
+		switch p.ID {
+		case requestID:
+			body.WriteString(p.Body)
+		case terminatorID:
+			// Source servers send an extra packet after the echo, read it so it is not mistaken for the next response
+			if p.Body == "" {
+				readPacket(c.conn)
+			}
+			return body.String(), true, nil
+		}
+	}
+}
+
+// Tells whether the server closed the idle connection.
+// Every response is read in full, so anything but a timeout means the connection can not be used.
+func (c *Client) closedByServer() bool {
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+
+	var b [1]byte
+	_, err := c.conn.Read(b[:])
+
+	var netErr net.Error
+	return !errors.As(err, &netErr) || !netErr.Timeout()
+}
+
+func (c *Client) connect() error {
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to rcon: %v", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	authID := c.newID()
+	if err := writePacket(conn, packet{ID: authID, Type: typeAuth, Body: c.password}); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send rcon password: %v", err)
+	}
+
+	for {
+		p, err := readPacket(conn)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to read rcon auth response: %v", err)
+		}
+
+		// Source servers send an empty response value before the auth response
+		if p.Type != typeAuthResponse {
+			continue
+		}
+
+		if p.ID == -1 {
+			conn.Close()
+			return ErrAuthFailed
+		}
+
+		if p.ID != authID {
+			conn.Close()
+			return fmt.Errorf("unexpected rcon auth response id: %d", p.ID)
+		}
+
+		break
+	}
+
+	c.conn = conn
+	return nil
+}
+
+func (c *Client) close() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Client) newID() int32 {
+	c.nextID++
+	if c.nextID <= 0 {
+		c.nextID = 1
+	}
+	return c.nextID
+}
@@ -0,0 +1,241 @@
+package rcon
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeServer answers RCON requests like a Minecraft server
+type fakeServer struct {
+	listener net.Listener
+	password string
+	// splits the response to a command into packets
+	respond func(command string) []string
+	// closes the connection after reading a command instead of answering it
+	dropAfterCommand bool
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	commands []string
+	logins   int
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &fakeServer{
+		listener: listener,
+		password: password,
+		respond:  func(command string) []string { return []string{"ran " + command} },
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		s.disconnectAll()
+	})
+
+	go s.accept()
+	return s
+}
+
+func (s *fakeServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		p, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p.Type {
+		case typeAuth:
+			if p.Body != s.password {
+				writePacket(conn, packet{ID: -1, Type: typeAuthResponse})
+				return
+			}
+			s.mu.Lock()
+			s.logins++
+			s.mu.Unlock()
+			writePacket(conn, packet{ID: p.ID, Type: typeAuthResponse})
+		case typeExecCommand:
+			s.mu.Lock()
+			s.commands = append(s.commands, p.Body)
+			drop, respond := s.dropAfterCommand, s.respond
+			s.mu.Unlock()
+
+			if drop {
+				return
+			}
+			for _, body := range respond(p.Body) {
+				writePacket(conn, packet{ID: p.ID, Type: typeResponseValue, Body: body})
+			}
+		case typeResponseValue:
+			writePacket(conn, packet{ID: p.ID, Type: typeResponseValue, Body: "Unknown request 0"})
+		}
+	}
+}
+
+// Closes every open connection, like a restart of the server
+func (s *fakeServer) disconnectAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) loginCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logins
+}
+
+func (s *fakeServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+func TestExecute(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	client := NewClient(server.address(), "secret")
+	defer client.Close()
+
+	response, err := client.Execute("list")
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if response != "ran list" {
+		t.Fatalf("response = %q, want %q", response, "ran list")
+	}
+
+	// the connection is kept between commands
+	if _, err := client.Execute("time query daytime"); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if logins := server.loginCount(); logins != 1 {
+		t.Fatalf("logged in %d times, want 1", logins)
+	}
+}
+
+func TestExecuteAuthFailed(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	client := NewClient(server.address(), "wrong")
+	defer client.Close()
+
+	_, err := client.Execute("list")
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Execute error = %v, want ErrAuthFailed", err)
+	}
+	if commands := server.received(); len(commands) != 0 {
+		t.Fatalf("server received %v without a login", commands)
+	}
+}
+
+func TestExecuteMultiPacketResponse(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	parts := []string{strings.Repeat("a", 4096), strings.Repeat("b", 4096), "c"}
+	server.respond = func(command string) []string {
+		if command == "help" {
+			return parts
+		}
+		return []string{"ran " + command}
+	}
+
+	client := NewClient(server.address(), "secret")
+	defer client.Close()
+
+	response, err := client.Execute("help")
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if response != strings.Join(parts, "") {
+		t.Fatalf("response has %d bytes, want %d", len(response), len(strings.Join(parts, "")))
+	}
+
+	// the next command is not answered with what was left of the previous response
+	if response, err := client.Execute("list"); err != nil || response != "ran list" {
+		t.Fatalf("Execute = %q, %v, want %q", response, err, "ran list")
+	}
+}
+
+func TestExecuteReconnects(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	client := NewClient(server.address(), "secret")
+	defer client.Close()
+
+	if _, err := client.Execute("list"); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	server.disconnectAll()
+
+	response, err := client.Execute("list")
+	if err != nil {
+		t.Fatalf("Execute after the server closed the connection: %v", err)
+	}
+	if response != "ran list" {
+		t.Fatalf("response = %q, want %q", response, "ran list")
+	}
+	if logins := server.loginCount(); logins != 2 {
+		t.Fatalf("logged in %d times, want 2", logins)
+	}
+}
+
+func TestExecuteDoesNotResendReceivedCommand(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	server.dropAfterCommand = true
+
+	client := NewClient(server.address(), "secret")
+	defer client.Close()
+
+	if _, err := client.Execute("give player diamond 64"); err == nil {
+		t.Fatal("Execute succeeded without a response")
+	}
+	if commands := server.received(); len(commands) != 1 {
+		t.Fatalf("server received %v, want the command once", commands)
+	}
+}
+
+func TestExecuteServerDown(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	address := server.address()
+	server.listener.Close()
+
+	client := NewClient(address, "secret")
+	defer client.Close()
+
+	if _, err := client.Execute("list"); err == nil {
+		t.Fatal("Execute succeeded without a server")
+	}
+}
@@ -0,0 +1,69 @@
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types of the Source RCON protocol, also used by Minecraft
+const (
+	typeResponseValue int32 = 0
+	typeExecCommand   int32 = 2
+	typeAuthResponse  int32 = 2
+	typeAuth          int32 = 3
+)
+
+// Largest packet a server may send, Minecraft splits longer responses into several packets
+const maxPacketSize = 4096 + 10
+
+// Size of the id and the type fields plus the two terminating null bytes
+const packetOverhead = 10
+
+type packet struct {
+	ID   int32
+	Type int32
+	Body string
+}
+
+func writePacket(w io.Writer, p packet) error {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.LittleEndian, int32(len(p.Body)+packetOverhead))
+	binary.Write(&buf, binary.LittleEndian, p.ID)
+	binary.Write(&buf, binary.LittleEndian, p.Type)
+	buf.WriteString(p.Body)
+	buf.Write([]byte{0, 0})
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readPacket(r io.Reader) (packet, error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return packet{}, err
+	}
+
+	if size < packetOverhead || size > maxPacketSize {
+		return packet{}, fmt.Errorf("invalid packet size: %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return packet{}, err
+	}
+
+	body := data[8 : size-2]
+	if !bytes.Equal(data[size-2:], []byte{0, 0}) {
+		return packet{}, errors.New("packet is not null terminated")
+	}
+
+	return packet{
+		ID:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		Type: int32(binary.LittleEndian.Uint32(data[4:8])),
+		Body: string(body),
+	}, nil
+}
@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+var jobActions = []string{
+	internal.JobActionStart,
+	internal.JobActionStop,
+	internal.JobActionRestart,
+	internal.JobActionCommand,
+	internal.JobActionBackup,
+}
+
+// Job with when it runs next
+type JobStatus struct {
+	internal.ScheduledJob
+	// Nil when the job is disabled
+	NextRunAt *time.Time `json:"nextRunAt"`
+}
+
+// Fields of a job to change, nil fields are kept
+type JobChanges struct {
+	Name     *string `json:"name"`
+	Schedule *string `json:"schedule"`
+	Action   *string `json:"action"`
+	Command  *string `json:"command"`
+	Enabled  *bool   `json:"enabled"`
+}
+
+// Gets all jobs, oldest first
+func (s *Scheduler) List() ([]JobStatus, error) {
+	jobs := []internal.ScheduledJob{}
+	if err := s.db.Order("id").Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get jobs: %v", err)
+	}
+
+	statuses := make([]JobStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = s.status(job)
+	}
+	return statuses, nil
+}
+
+func (s *Scheduler) Get(ID uint) (JobStatus, error) {
+	job, err := s.get(ID)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return s.status(job), nil
+}
+
+func (s *Scheduler) get(ID uint) (internal.ScheduledJob, error) {
+	var job internal.ScheduledJob
+	if err := s.db.First(&job, ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return internal.ScheduledJob{}, ErrJobNotFound
+		}
+		return internal.ScheduledJob{}, fmt.Errorf("failed to get job: %v", err)
+	}
+	return job, nil
+}
+
+func (s *Scheduler) status(job internal.ScheduledJob) JobStatus {
+	return JobStatus{ScheduledJob: job, NextRunAt: s.nextRun(job.ID)}
+}
+
+// Saves the job and schedules it
+func (s *Scheduler) Create(c context.Context, job internal.ScheduledJob) (JobStatus, error) {
+	job = internal.ScheduledJob{
+		Name:     job.Name,
+		Schedule: job.Schedule,
+		Action:   job.Action,
+		Command:  job.Command,
+		Enabled:  job.Enabled,
+	}
+
+	if err := s.validate(c, &job); err != nil {
+		return JobStatus{}, err
+	}
+
+	if err := s.db.Create(&job).Error; err != nil {
+		return JobStatus{}, fmt.Errorf("failed to save job: %v", err)
+	}
+
+	if err := s.schedule(job); err != nil {
+		return JobStatus{}, err
+	}
+	return s.status(job), nil
+}
+
+// Changes the job, a run in progress finishes with the job as it was
+func (s *Scheduler) Update(c context.Context, ID uint, changes JobChanges) (JobStatus, error) {
+	job, err := s.get(ID)
+	if err != nil {
+		return JobStatus{}, err
+	}
+
+	if changes.Name != nil {
+		job.Name = *changes.Name
+	}
+	if changes.Schedule != nil {
+		job.Schedule = *changes.Schedule
+	}
+	if changes.Action != nil {
+		job.Action = *changes.Action
+	}
+	if changes.Command != nil {
+		job.Command = *changes.Command
+	}
+	if changes.Enabled != nil {
+		job.Enabled = *changes.Enabled
+	}
+
+	if err := s.validate(c, &job); err != nil {
+		return JobStatus{}, err
+	}
+
+	// the columns of the last run belong to the run in progress
+	if err := s.db.Model(&job).Select("Name", "Schedule", "Action", "Command", "Enabled").Updates(&job).Error; err != nil {
+		return JobStatus{}, fmt.Errorf("failed to save job: %v", err)
+	}
+
+	if err := s.schedule(job); err != nil {
+		return JobStatus{}, err
+	}
+	return s.status(job), nil
+}
+
+// Deletes the job, a run in progress is not interrupted
+func (s *Scheduler) Delete(ID uint) error {
+	job, err := s.get(ID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(&job).Error; err != nil {
+		return fmt.Errorf("failed to delete job: %v", err)
+	}
+
+	s.unschedule(ID)
+	return nil
+}
+
+// Runs the job now in the background, outside of its schedule
+func (s *Scheduler) Run(ID uint) error {
+	job, err := s.get(ID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tryStart(ID) {
+		return ErrJobRunning
+	}
+	go s.execute(job)
+	return nil
+}
+
+// Normalizes the job and checks its schedule, action and command
+func (s *Scheduler) validate(c context.Context, job *internal.ScheduledJob) error {
+	job.Name = strings.TrimSpace(job.Name)
+	job.Schedule = strings.TrimSpace(job.Schedule)
+	job.Command = strings.TrimSpace(job.Command)
+
+	if _, err := cron.ParseStandard(job.Schedule); err != nil {
+		return fmt.Errorf("%w: invalid schedule: %v", ErrInvalidJob, err)
+	}
+
+	if !slices.Contains(jobActions, job.Action) {
+		return fmt.Errorf("%w: unknown action %q, expected one of %s", ErrInvalidJob, job.Action, strings.Join(jobActions, ", "))
+	}
+
+	if job.Action != internal.JobActionCommand {
+		job.Command = ""
+		return nil
+	}
+
+	if job.Command == "" {
+		return fmt.Errorf("%w: the command action needs a command", ErrInvalidJob)
+	}
+
+	// the command is checked against the game that runs now, without a server it is checked when it runs
+	err := s.target.CheckCommand(c, job.Command)
+	var policyErr *service.PolicyError
+	switch {
+	case errors.As(err, &policyErr), errors.Is(err, service.ErrNotSupported):
+		return fmt.Errorf("%w: %v", ErrInvalidJob, err)
+	case err != nil && !errors.Is(err, service.ErrContainerNotFound):
+		return err
+	}
+	return nil
+}
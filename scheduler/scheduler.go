@@ -0,0 +1,313 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/history"
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	// How often due jobs are looked for, schedules have a resolution of a minute
+	tickInterval = time.Second
+	// How long a job can run before it is abandoned
+	jobTimeout = time.Hour
+	// Characters of the command output kept with the last run
+	maxOutputLength = 4096
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrInvalidJob  = errors.New("invalid job")
+	// Returned when a job is run while its previous run has not finished
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// Target is the part of the service controller the jobs act on
+type Target interface {
+	StartService(c context.Context) error
+	StopService(c context.Context) error
+	RestartService(c context.Context) error
+	CheckCommand(c context.Context, cmd string) error
+	RunCommand(c context.Context, cmd string) (service.ExecResult, error)
+}
+
+// Backups is the part of the backup manager the backup action uses
+type Backups interface {
+	Create(c context.Context, trigger string) (internal.Backup, error)
+}
+
+// Scheduler runs the jobs stored in the database on their cron schedules.
+// Runs missed while the instance was down are not made up for, and a job is never run twice at the same time.
+type Scheduler struct {
+	db      *gorm.DB
+	target  Target
+	backups Backups
+
+	mu      sync.Mutex
+	entries map[uint]*entry
+	running map[uint]bool
+
+	// cancels the jobs in progress when the scheduler stops
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   sync.WaitGroup
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Enabled job with its parsed schedule
+type entry struct {
+	job      internal.ScheduledJob
+	schedule cron.Schedule
+	next     time.Time
+}
+
+func NewScheduler(db *gorm.DB, target Target, backups Backups) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		db:      db,
+		target:  target,
+		backups: backups,
+		entries: make(map[uint]*entry),
+		running: make(map[uint]bool),
+		ctx:     ctx,
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Loads the jobs and starts running them on their schedules
+func (s *Scheduler) Start() error {
+	if err := s.load(); err != nil {
+		// nothing runs, Stop must not wait for it
+		close(s.done)
+		return err
+	}
+
+	go s.run()
+	return nil
+}
+
+func (s *Scheduler) load() error {
+	// a run still in progress when the instance went down never finished
+	if err := s.db.Model(&internal.ScheduledJob{}).Where("last_status = ?", internal.JobStatusRunning).UpdateColumns(map[string]interface{}{
+		"last_status": internal.JobStatusFailed,
+		"last_error":  "interrupted by a restart of the instance",
+	}).Error; err != nil {
+		return fmt.Errorf("failed to recover interrupted jobs: %v", err)
+	}
+
+	var jobs []internal.ScheduledJob
+	if err := s.db.Find(&jobs).Error; err != nil {
+		return fmt.Errorf("failed to get jobs: %v", err)
+	}
+
+	for _, job := range jobs {
+		if err := s.schedule(job); err != nil {
+			log.Printf("Failed to schedule job %d: %v", job.ID, err)
+		}
+	}
+	return nil
+}
+
+// Stops scheduling jobs, cancels the ones in progress and waits for them to end
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	<-s.done
+
+	// taken so no job starts between the cancel and the wait
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+
+	s.jobs.Wait()
+}
+
+// Adds the job to the schedule, or removes it when it is disabled
+func (s *Scheduler) schedule(job internal.ScheduledJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !job.Enabled {
+		delete(s.entries, job.ID)
+		return nil
+	}
+
+	schedule, err := cron.ParseStandard(job.Schedule)
+	if err != nil {
+		delete(s.entries, job.ID)
+		return err
+	}
+
+	s.entries[job.ID] = &entry{job: job, schedule: schedule, next: schedule.Next(time.Now())}
+	return nil
+}
+
+func (s *Scheduler) unschedule(ID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, ID)
+}
+
+// Gets when the job runs next, nil when it is disabled
+func (s *Scheduler) nextRun(ID uint) *time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[ID]
+	if !ok {
+		return nil
+	}
+	next := e.next
+	return &next
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.runDue(now)
+		}
+	}
+}
+
+func (s *Scheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ID, e := range s.entries {
+		if e.next.After(now) {
+			continue
+		}
+		e.next = e.schedule.Next(now)
+
+		if !s.tryStart(ID) {
+			log.Printf("Skipped job %d, its previous run has not finished", ID)
+			continue
+		}
+		go s.execute(e.job)
+	}
+}
+
+// Marks the job as running unless it already is or the scheduler stopped, the lock must be held
+func (s *Scheduler) tryStart(ID uint) bool {
+	if s.running[ID] || s.ctx.Err() != nil {
+		return false
+	}
+	s.running[ID] = true
+	s.jobs.Add(1)
+	return true
+}
+
+// Runs the job and records the outcome as its last run
+func (s *Scheduler) execute(job internal.ScheduledJob) {
+	defer s.jobs.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, job.ID)
+		s.mu.Unlock()
+	}()
+
+	started := time.Now()
+	s.record(job.ID, map[string]interface{}{
+		"last_run_at": started,
+		"last_status": internal.JobStatusRunning,
+		"last_output": "",
+		"last_error":  "",
+	})
+
+	ctx, cancel := context.WithTimeout(s.ctx, jobTimeout)
+	defer cancel()
+
+	output, err := s.perform(ctx, job)
+	if len(output) > maxOutputLength {
+		output = output[:maxOutputLength]
+	}
+
+	if err != nil {
+		log.Printf("Job %d (%s) failed: %v", job.ID, job.Action, err)
+		s.record(job.ID, map[string]interface{}{
+			"last_status": internal.JobStatusFailed,
+			"last_output": output,
+			"last_error":  err.Error(),
+		})
+		return
+	}
+
+	s.record(job.ID, map[string]interface{}{
+		"last_status": internal.JobStatusSucceeded,
+		"last_output": output,
+	})
+}
+
+// Saves the columns of the last run, the job may have been edited in the meantime so nothing else is written
+func (s *Scheduler) record(ID uint, columns map[string]interface{}) {
+	if err := s.db.Model(&internal.ScheduledJob{ID: ID}).UpdateColumns(columns).Error; err != nil {
+		log.Printf("Failed to save the run of job %d: %v", ID, err)
+	}
+}
+
+func (s *Scheduler) perform(c context.Context, job internal.ScheduledJob) (string, error) {
+	switch job.Action {
+	case internal.JobActionStart:
+		return "", s.target.StartService(c)
+	case internal.JobActionStop:
+		return "", s.target.StopService(c)
+	case internal.JobActionRestart:
+		return "", s.target.RestartService(c)
+	case internal.JobActionBackup:
+		backup, err := s.backups.Create(c, internal.BackupTriggerScheduled)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Created backup %s", backup.Name), nil
+	case internal.JobActionCommand:
+		return s.runCommand(c, job.Command)
+	default:
+		return "", fmt.Errorf("unknown action: %s", job.Action)
+	}
+}
+
+// Runs the game command the way a command of a user is run, and adds it to the command history
+func (s *Scheduler) runCommand(c context.Context, cmd string) (string, error) {
+	result, err := s.target.RunCommand(c, cmd)
+
+	// a rejected command never ran, so it is not part of the history
+	var policyErr *service.PolicyError
+	if !errors.As(err, &policyErr) {
+		record := history.NewCommandRecord(internal.SchedulerUserID, cmd, result, err)
+		if err := history.RecordCommand(s.db, &record); err != nil {
+			log.Printf("Failed to record command: %v", err)
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	output := service.JoinLines(result.Output)
+
+	if result.ExitCode != 0 {
+		return output, fmt.Errorf("command exited with code %d", result.ExitCode)
+	}
+	return output, nil
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeTarget runs commands from a table instead of a game server
+type fakeTarget struct {
+	// result of each command, commands missing from it are rejected by the policy
+	results map[string]service.ExecResult
+	// returned for every command when set
+	err error
+	ran []string
+}
+
+func (f *fakeTarget) StartService(c context.Context) error   { return nil }
+func (f *fakeTarget) StopService(c context.Context) error    { return nil }
+func (f *fakeTarget) RestartService(c context.Context) error { return nil }
+
+func (f *fakeTarget) CheckCommand(c context.Context, cmd string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.results[cmd]; !ok {
+		return &service.PolicyError{Rule: "deny", Command: cmd, Reason: "not allowed"}
+	}
+	return nil
+}
+
+func (f *fakeTarget) RunCommand(c context.Context, cmd string) (service.ExecResult, error) {
+	if err := f.CheckCommand(c, cmd); err != nil {
+		return service.ExecResult{}, err
+	}
+	f.ran = append(f.ran, cmd)
+	return f.results[cmd], nil
+}
+
+func newTestScheduler(t *testing.T, target *fakeTarget) (*Scheduler, *gorm.DB) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&internal.ScheduledJob{}, &internal.CommandRecord{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return NewScheduler(db, target, nil), db
+}
+
+func output(texts ...string) []service.LogLine {
+	lines := make([]service.LogLine, len(texts))
+	for i, text := range texts {
+		lines[i] = service.LogLine{Stream: service.StreamStdout, Text: text}
+	}
+	return lines
+}
+
+func TestCommandJobIsRecorded(t *testing.T) {
+	target := &fakeTarget{results: map[string]service.ExecResult{
+		"save-all":  {Output: output("Saving the game", "Saved the game")},
+		"whitelist": {Output: output("Unknown command"), ExitCode: 1},
+	}}
+	s, db := newTestScheduler(t, target)
+	ctx := context.Background()
+
+	out, err := s.perform(ctx, internal.ScheduledJob{Action: internal.JobActionCommand, Command: "save-all"})
+	if err != nil || out != "Saving the game\nSaved the game" {
+		t.Fatalf("perform = %q, %v", out, err)
+	}
+	if _, err := s.perform(ctx, internal.ScheduledJob{Action: internal.JobActionCommand, Command: "whitelist"}); err == nil {
+		t.Fatal("perform succeeded although the command failed")
+	}
+	// rejected commands never ran
+	if _, err := s.perform(ctx, internal.ScheduledJob{Action: internal.JobActionCommand, Command: "stop"}); err == nil {
+		t.Fatal("perform succeeded although the command was rejected")
+	}
+
+	var records []internal.CommandRecord
+	if err := db.Order("id").Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("recorded %d commands, want 2: %+v", len(records), records)
+	}
+
+	want := []internal.CommandRecord{
+		{UserID: internal.SchedulerUserID, Command: "save-all", Output: "Saving the game\nSaved the game"},
+		{UserID: internal.SchedulerUserID, Command: "whitelist", Output: "Unknown command", ExitCode: 1},
+	}
+	for i, record := range records {
+		if record.UserID != want[i].UserID || record.Command != want[i].Command || record.Output != want[i].Output || record.ExitCode != want[i].ExitCode || record.Error != "" {
+			t.Errorf("record %d = %+v, want %+v", i, record, want[i])
+		}
+	}
+}
+
+func TestCommandJobFailureIsRecorded(t *testing.T) {
+	target := &fakeTarget{err: service.ErrContainerNotFound}
+	s, db := newTestScheduler(t, target)
+
+	if _, err := s.perform(context.Background(), internal.ScheduledJob{Action: internal.JobActionCommand, Command: "save-all"}); !errors.Is(err, service.ErrContainerNotFound) {
+		t.Fatalf("perform error = %v, want ErrContainerNotFound", err)
+	}
+
+	var record internal.CommandRecord
+	if err := db.First(&record).Error; err != nil {
+		t.Fatalf("the command was not recorded: %v", err)
+	}
+	if record.ExitCode != -1 || record.Error != service.ErrContainerNotFound.Error() {
+		t.Fatalf("record = %+v, want the error", record)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		job       internal.ScheduledJob
+		targetErr error
+		wantErr   error
+	}{
+		{"restart", internal.ScheduledJob{Schedule: "0 4 * * *", Action: internal.JobActionRestart}, nil, nil},
+		{"descriptor", internal.ScheduledJob{Schedule: "@daily", Action: internal.JobActionBackup}, nil, nil},
+		{"command", internal.ScheduledJob{Schedule: "*/30 * * * *", Action: internal.JobActionCommand, Command: " save-all "}, nil, nil},
+		{"invalid schedule", internal.ScheduledJob{Schedule: "every day", Action: internal.JobActionRestart}, nil, ErrInvalidJob},
+		{"unknown action", internal.ScheduledJob{Schedule: "@daily", Action: "reboot"}, nil, ErrInvalidJob},
+		{"missing command", internal.ScheduledJob{Schedule: "@daily", Action: internal.JobActionCommand}, nil, ErrInvalidJob},
+		{"rejected command", internal.ScheduledJob{Schedule: "@daily", Action: internal.JobActionCommand, Command: "stop"}, nil, ErrInvalidJob},
+		{"commands not supported", internal.ScheduledJob{Schedule: "@daily", Action: internal.JobActionCommand, Command: "save-all"}, service.ErrNotSupported, ErrInvalidJob},
+		// without a server the command is checked when it runs
+		{"no server", internal.ScheduledJob{Schedule: "@daily", Action: internal.JobActionCommand, Command: "stop"}, service.ErrContainerNotFound, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, _ := newTestScheduler(t, &fakeTarget{results: map[string]service.ExecResult{"save-all": {}}, err: test.targetErr})
+
+			job := test.job
+			if err := s.validate(context.Background(), &job); !errors.Is(err, test.wantErr) {
+				t.Fatalf("validate error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	s, _ := newTestScheduler(t, &fakeTarget{})
+	job := internal.ScheduledJob{Name: " nightly ", Schedule: "@daily", Action: internal.JobActionRestart, Command: "save-all"}
+	if err := s.validate(context.Background(), &job); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if job.Name != "nightly" || job.Command != "" {
+		t.Fatalf("job = %+v, want the name trimmed and the command dropped", job)
+	}
+}
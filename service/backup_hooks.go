@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// Gets the volumes declared by the service, the host paths hold the game files
+func (s *ServiceController) GetVolumes(c context.Context) ([]VolumeBinding, error) {
+	conf, err := s.getServiceConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return toVolumeBindings(conf.Volumes), nil
+}
+
+// Flushes the game state and stops the game from writing its files, nothing is needed when it is not running
+func (s *ServiceController) PrepareBackup(c context.Context) error {
+	return s.runBackupCommands(c, func(strategy ServiceStrategy) []string { return strategy.PreBackupCommands() })
+}
+
+// Lets the game write its files again after PrepareBackup
+func (s *ServiceController) FinishBackup(c context.Context) error {
+	return s.runBackupCommands(c, func(strategy ServiceStrategy) []string { return strategy.PostBackupCommands() })
+}
+
+func (s *ServiceController) runBackupCommands(c context.Context, commands func(ServiceStrategy) []string) error {
+	running, err := s.IsRunning(c)
+	if err != nil || !running {
+		return err
+	}
+
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range commands(*strategy) {
+		if _, err := s.runInternalGameCommand(c, *strategy, cmd); err != nil {
+			return fmt.Errorf("failed to run %s: %v", cmd, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Longest command accepted from a user, Minecraft truncates longer RCON commands anyway
+const defaultMaxCommandLength = 1000
+
+// Names of the rules a command can be rejected by
+const (
+	RuleLength     = "length"
+	RuleCharacters = "characters"
+	RuleSyntax     = "syntax"
+	RuleDenied     = "denied"
+	RuleNotAllowed = "notAllowed"
+	RuleArguments  = "arguments"
+)
+
+// CommandRule describes the accepted form of a single game command
+type CommandRule struct {
+	MinArgs int
+	// Maximum number of arguments, -1 for no limit
+	MaxArgs int
+	// Every argument has to match it when set
+	ArgPattern *regexp.Regexp
+}
+
+// CommandPolicy decides which game commands users are allowed to run
+type CommandPolicy struct {
+	// Commands users can run, every command that is not denied is allowed when empty
+	Allowed map[string]CommandRule
+	// Commands users can never run, e.g. because the api manages them
+	Denied    []string
+	MaxLength int
+}
+
+// PolicyError explains which rule a command was rejected by
+type PolicyError struct {
+	Rule    string `json:"rule"`
+	Command string `json:"command"`
+	Reason  string `json:"reason"`
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("command rejected by %s rule: %s", e.Rule, e.Reason)
+}
+
+// GameCommand is a user command that passed the policy
+type GameCommand struct {
+	// The input split into arguments, passed to exec without a shell
+	Args []string
+}
+
+// Joins the checked arguments back into the line sent over RCON, so what runs is what the policy checked
+func (g GameCommand) Line() string {
+	return strings.Join(g.Args, " ")
+}
+
+// Checks the input against the policy and splits it into arguments
+func (p CommandPolicy) Check(input string) (GameCommand, error) {
+	input = strings.TrimSpace(input)
+	// commands typed in game start with a slash, RCON expects them without it
+	input = strings.TrimPrefix(input, "/")
+
+	maxLength := p.MaxLength
+	if maxLength == 0 {
+		maxLength = defaultMaxCommandLength
+	}
+
+	if len(input) > maxLength {
+		return GameCommand{}, &PolicyError{Rule: RuleLength, Reason: fmt.Sprintf("commands can not be longer than %d characters", maxLength)}
+	}
+
+	for _, r := range input {
+		if unicode.IsControl(r) {
+			return GameCommand{}, &PolicyError{Rule: RuleCharacters, Reason: "commands can not contain control characters or line breaks"}
+		}
+	}
+
+	args, err := Tokenize(input)
+	if err != nil {
+		return GameCommand{}, &PolicyError{Rule: RuleSyntax, Reason: err.Error()}
+	}
+
+	if len(args) == 0 {
+		return GameCommand{}, &PolicyError{Rule: RuleSyntax, Reason: "command is empty"}
+	}
+
+	name := strings.ToLower(args[0])
+
+	for _, denied := range p.Denied {
+		if name == denied {
+			return GameCommand{}, &PolicyError{Rule: RuleDenied, Command: name, Reason: fmt.Sprintf("%s is not allowed on this server", name)}
+		}
+	}
+
+	if len(p.Allowed) > 0 {
+		rule, ok := p.Allowed[name]
+		if !ok {
+			return GameCommand{}, &PolicyError{Rule: RuleNotAllowed, Command: name, Reason: fmt.Sprintf("%s is not a supported command", name)}
+		}
+
+		if err := rule.check(name, args[1:]); err != nil {
+			return GameCommand{}, err
+		}
+	}
+
+	// the name runs the way it was checked
+	args[0] = name
+	return GameCommand{Args: args}, nil
+}
+
+func (r CommandRule) check(name string, args []string) error {
+	if len(args) < r.MinArgs {
+		return &PolicyError{Rule: RuleArguments, Command: name, Reason: fmt.Sprintf("%s needs at least %d arguments", name, r.MinArgs)}
+	}
+
+	if r.MaxArgs >= 0 && len(args) > r.MaxArgs {
+		return &PolicyError{Rule: RuleArguments, Command: name, Reason: fmt.Sprintf("%s takes at most %d arguments", name, r.MaxArgs)}
+	}
+
+	if r.ArgPattern != nil {
+		for _, arg := range args {
+			if !r.ArgPattern.MatchString(arg) {
+				return &PolicyError{Rule: RuleArguments, Command: name, Reason: fmt.Sprintf("invalid argument: %s", arg)}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Splits the input into arguments on whitespace.
+// Single and double quotes group words into one argument, a backslash escapes the next character.
+func Tokenize(input string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range input {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("command ends with an escape character")
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"list", []string{"list"}, false},
+		{"  give   Steve  diamond ", []string{"give", "Steve", "diamond"}, false},
+		{`say "hello world"`, []string{"say", "hello world"}, false},
+		{`say 'it "works"'`, []string{"say", `it "works"`}, false},
+		{`say it\'s`, []string{"say", "it's"}, false},
+		{`say ""`, []string{"say", ""}, false},
+		{`say 'a\b'`, []string{"say", `a\b`}, false},
+		{`say "open`, nil, true},
+		{`say end\`, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := Tokenize(test.input)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Tokenize(%q) error = %v, wantErr %v", test.input, err, test.wantErr)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("Tokenize(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandPolicyCheck(t *testing.T) {
+	policy := CommandPolicy{
+		Allowed: map[string]CommandRule{
+			"say": {MinArgs: 1, MaxArgs: -1},
+			"op":  {MinArgs: 1, MaxArgs: 1, ArgPattern: regexp.MustCompile(`^[A-Za-z0-9_]+$`)},
+		},
+		Denied:    []string{"stop"},
+		MaxLength: 20,
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		wantLine string
+		wantRule string
+	}{
+		{"allowed", "op Steve", "op Steve", ""},
+		{"leading slash", "/op Steve", "op Steve", ""},
+		{"quoted message", `say "hi  there"`, "say hi  there", ""},
+		{"case of the name", "OP Steve", "op Steve", ""},
+		{"too long", "say " + "a very long message", "", RuleLength},
+		{"line break", "say hi\nstop", "", RuleCharacters},
+		{"unterminated quote", `say "hi`, "", RuleSyntax},
+		{"empty", "   ", "", RuleSyntax},
+		{"denied", "stop", "", RuleDenied},
+		{"denied in upper case", "STOP", "", RuleDenied},
+		{"not allowed", "kill @a", "", RuleNotAllowed},
+		{"missing argument", "op", "", RuleArguments},
+		{"extra argument", "op Steve Alex", "", RuleArguments},
+		{"invalid argument", `op "Steve stop"`, "", RuleArguments},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, err := policy.Check(test.input)
+
+			var policyErr *PolicyError
+			if test.wantRule != "" {
+				if !errors.As(err, &policyErr) || policyErr.Rule != test.wantRule {
+					t.Fatalf("Check(%q) error = %v, want the %s rule", test.input, err, test.wantRule)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Check(%q): %v", test.input, err)
+			}
+			if line := command.Line(); line != test.wantLine {
+				t.Fatalf("Check(%q) runs %q, want %q", test.input, line, test.wantLine)
+			}
+		})
+	}
+}
+
+func TestCommandPolicyAllowsAnythingNotDenied(t *testing.T) {
+	policy := CommandPolicy{Denied: []string{"stop"}}
+
+	if _, err := policy.Check("anything goes"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if _, err := policy.Check("stop"); err == nil {
+		t.Fatal("Check allowed a denied command")
+	}
+}
+
+func TestConsoleInputLines(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"say hi", []string{"say hi"}},
+		{"say hi\n", []string{"say hi"}},
+		{"say hi\r\nstop\n", []string{"say hi", "stop"}},
+		{"\n  \n", nil},
+		{"list\n\n  time set day  ", []string{"list", "time set day"}},
+	}
+
+	for _, test := range tests {
+		if got := ConsoleInputLines(test.input); !slices.Equal(got, test.want) {
+			t.Errorf("ConsoleInputLines(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// How many lines can be buffered before the log reader waits for the viewer
+const consoleBufferSize = 256
+
+// Gets the whole output of the service container
+func (s *ServiceController) GetConsole(c context.Context) ([]LogLine, error) {
+	out, err := s.docker.GetLogs(c, SERVICE_CONTAINER_ID, LogOptions{Tail: "all"})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	lines, err := DecodeLogs(out, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read console: %v", err)
+	}
+	return lines, nil
+}
+
+// Follows the output of the service container, starting with the last tail lines
+// and the lines since the given time, if any.
+// The lines channel is closed when the log stream ends or the context is done.
+// Sending blocks while the channel is full, which slows down the log reader to the pace of the viewer.
+func (s *ServiceController) FollowConsole(c context.Context, tail string, since string) (<-chan LogLine, <-chan error) {
+	lines := make(chan LogLine, consoleBufferSize)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(lines)
+
+		out, err := s.docker.GetLogs(c, SERVICE_CONTAINER_ID, LogOptions{Tail: tail, Since: since, Follow: true})
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer out.Close()
+
+		decoder := NewLogDecoder(out, true)
+		for {
+			line, err := decoder.Next()
+			if err != nil {
+				if !errors.Is(err, io.EOF) && c.Err() == nil {
+					errs <- fmt.Errorf("failed to read console: %v", err)
+				}
+				return
+			}
+
+			select {
+			case lines <- line:
+			case <-c.Done():
+				return
+			}
+		}
+	}()
+
+	return lines, errs
+}
+
+// ConsoleSession is an interactive session attached to the stdin and stdout of the service container
+type ConsoleSession struct {
+	conn    types.HijackedResponse
+	decoder *LogDecoder
+}
+
+// Attaches to the service container, which must have been created with stdin open
+func (s *ServiceController) AttachConsole(c context.Context) (*ConsoleSession, error) {
+	conn, err := s.docker.Attach(c, SERVICE_CONTAINER_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsoleSession{
+		conn:    conn,
+		decoder: NewLogDecoder(conn.Reader, false),
+	}, nil
+}
+
+// Splits the input of a viewer into the commands to send, blank lines are dropped
+func ConsoleInputLines(input string) []string {
+	var lines []string
+	for _, line := range strings.Split(input, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Sends a line to the stdin of the game, it must have been checked with CheckCommand
+func (s *ConsoleSession) Send(line string) error {
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+
+	if _, err := s.conn.Conn.Write([]byte(line)); err != nil {
+		return fmt.Errorf("failed to write to console: %v", err)
+	}
+	return nil
+}
+
+// Returns the next line of output, or io.EOF once the container stopped
+func (s *ConsoleSession) Next() (LogLine, error) {
+	line, err := s.decoder.Next()
+	if err == nil {
+		line.Timestamp = time.Now()
+	}
+	return line, err
+}
+
+func (s *ConsoleSession) Close() {
+	s.conn.Close()
+}
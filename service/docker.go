@@ -2,10 +2,17 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	imageTypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 )
@@ -22,55 +29,323 @@ type VolumeBinding struct {
 }
 
 type Container struct {
-	ID      string
+	ID string
+	// Image the container was created from, as given on creation
+	Image     string
+	State     string
+	Running   bool
+	Name      string
+	IPAddress string
+	Env       map[string]string
+	Ports     []PortBinding
+	Volumes   []VolumeBinding
+}
+
+// Returned when the container does not exist
+var ErrContainerNotFound = errors.New("container not found")
+
+// ContainerConfig describes a container to create
+type ContainerConfig struct {
 	Image   string
-	Running bool
-	Name    string
 	Env     map[string]string
 	Ports   []PortBinding
 	Volumes []VolumeBinding
+	// Keep stdin open so the console can be attached to
+	OpenStdin bool
 }
 
-type DockerClient struct {
+// Resource usage of a running container
+type ContainerStats struct {
+	// Percentage of one cpu, can go above 100 on several cpus
+	CPUPercent  float64 `json:"cpuPercent"`
+	MemoryUsage uint64  `json:"memoryUsage"`
+	MemoryLimit uint64  `json:"memoryLimit"`
+	NetworkRx   uint64  `json:"networkRx"`
+	NetworkTx   uint64  `json:"networkTx"`
+}
+
+// DockerClient is the part of docker the service controller relies on.
+// Errors for missing containers wrap ErrContainerNotFound.
+type DockerClient interface {
+	GetContainer(c context.Context, ID string) (Container, error)
+	PullImage(c context.Context, image string) error
+	CreateContainer(c context.Context, name string, config ContainerConfig) (string, error)
+	StartContainer(c context.Context, ID string) error
+	StopContainer(c context.Context, ID string, timeout time.Duration) error
+	RemoveContainer(c context.Context, ID string) error
+	RenameContainer(c context.Context, ID string, name string) error
+	GetLogs(c context.Context, ID string, options LogOptions) (io.ReadCloser, error)
+	Attach(c context.Context, ID string) (types.HijackedResponse, error)
+	Exec(c context.Context, ID string, cmd []string) (ExecResult, error)
+	Stats(c context.Context, ID string) (ContainerStats, error)
+}
+
+// engineClient talks to the docker engine of the instance
+type engineClient struct {
 	docker *client.Client
 }
 
-func NewDockerClient() (*DockerClient, error) {
+func NewDockerClient() (DockerClient, error) {
 	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create docker client: %v", err)
 	}
 
-	return &DockerClient{
+	return &engineClient{
 		docker: docker,
 	}, nil
 }
 
-func (d *DockerClient) GetContainer(c context.Context, ID string) (Container, error) {
+func (d *engineClient) GetContainer(c context.Context, ID string) (Container, error) {
 	container, err := d.docker.ContainerInspect(c, ID)
 	if err != nil {
+		if client.IsErrNotFound(err) {
+			return Container{}, fmt.Errorf("%w: %s", ErrContainerNotFound, ID)
+		}
 		return Container{}, fmt.Errorf("failed to inspect container: %v", err)
 	}
 
 	return mapToContainer(container), nil
 }
 
-func (d *DockerClient) CreateContainer() {
+// Pulls the image unless it is already present
+func (d *engineClient) PullImage(c context.Context, image string) error {
+	if _, _, err := d.docker.ImageInspectWithRaw(c, image); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect image: %v", err)
+	}
+
+	out, err := d.docker.ImagePull(c, image, imageTypes.PullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull image: %v", err)
+	}
+	defer out.Close()
+
+	// the pull is only done once its progress has been read to the end
+	if _, err := io.Copy(io.Discard, out); err != nil {
+		return fmt.Errorf("failed to pull image: %v", err)
+	}
+	return nil
+}
+
+// Creates the container with the given name, the image must be present
+func (d *engineClient) CreateContainer(c context.Context, name string, config ContainerConfig) (string, error) {
+	resp, err := d.docker.ContainerCreate(c, &container.Config{
+		Image:     config.Image,
+		Env:       formatEnv(config.Env),
+		OpenStdin: config.OpenStdin,
+	}, &container.HostConfig{
+		PortBindings: formatPorts(config.Ports),
+		Binds:        formatVolumes(config.Volumes),
+	}, &network.NetworkingConfig{}, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %v", err)
+	}
+	return resp.ID, nil
+}
+
+func (d *engineClient) StartContainer(c context.Context, ID string) error {
+	if err := d.docker.ContainerStart(c, ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %v", err)
+	}
+	return nil
+}
+
+// Removes the container, the host directories bound to it are kept
+func (d *engineClient) RemoveContainer(c context.Context, ID string) error {
+	if err := d.docker.ContainerRemove(c, ID, container.RemoveOptions{RemoveVolumes: false}); err != nil {
+		return fmt.Errorf("failed to remove container: %v", err)
+	}
+	return nil
+}
+
+// Stops the container, killing it if it does not exit within the timeout,
+// and waits until it is no longer running
+func (d *engineClient) StopContainer(c context.Context, ID string, timeout time.Duration) error {
+	timeoutSeconds := int(timeout.Seconds())
+	if err := d.docker.ContainerStop(c, ID, container.StopOptions{Timeout: &timeoutSeconds}); err != nil {
+		return fmt.Errorf("failed to stop container: %v", err)
+	}
+
+	statusCh, errCh := d.docker.ContainerWait(c, ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to wait for container to stop: %v", err)
+	case <-statusCh:
+		return nil
+	}
+}
+
+func (d *engineClient) RenameContainer(c context.Context, ID string, name string) error {
+	if err := d.docker.ContainerRename(c, ID, name); err != nil {
+		return fmt.Errorf("failed to rename container: %v", err)
+	}
+	return nil
+}
+
+type LogOptions struct {
+	// Number of lines to show from the end of the logs, "all" shows every line
+	Tail string
+	// Only show logs since a timestamp or a relative time like 10m
+	Since  string
+	Follow bool
+}
 
+// Gets the multiplexed stdout and stderr logs of the container, with timestamps
+func (d *engineClient) GetLogs(c context.Context, ID string, options LogOptions) (io.ReadCloser, error) {
+	out, err := d.docker.ContainerLogs(c, ID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Follow:     options.Follow,
+		Tail:       options.Tail,
+		Since:      options.Since,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container logs: %w", err)
+	}
+	return out, nil
+}
+
+// Attaches to the stdin, stdout and stderr of the container
+func (d *engineClient) Attach(c context.Context, ID string) (types.HijackedResponse, error) {
+	resp, err := d.docker.ContainerAttach(c, ID, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return types.HijackedResponse{}, fmt.Errorf("failed to attach to container: %w", err)
+	}
+	return resp, nil
+}
+
+// Output and exit code of a command run inside a container
+type ExecResult struct {
+	Output   []LogLine `json:"output"`
+	ExitCode int       `json:"exitCode"`
+}
+
+// Runs a command inside the container and returns its output and exit code
+func (d *engineClient) Exec(c context.Context, ID string, cmd []string) (ExecResult, error) {
+	execID, err := d.docker.ContainerExecCreate(c, ID, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to create exec instance: %v", err)
+	}
+
+	resp, err := d.docker.ContainerExecAttach(c, execID.ID, types.ExecStartCheck{})
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to attach to exec instance: %v", err)
+	}
+	defer resp.Close()
+
+	output, err := DecodeLogs(resp.Reader, false)
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to read exec output: %v", err)
+	}
+
+	inspect, err := d.docker.ContainerExecInspect(c, execID.ID)
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to inspect exec instance: %v", err)
+	}
+
+	return ExecResult{Output: output, ExitCode: inspect.ExitCode}, nil
+}
+
+// Gets the resource usage of the container, averaged by docker over about a second
+func (d *engineClient) Stats(c context.Context, ID string) (ContainerStats, error) {
+	resp, err := d.docker.ContainerStats(c, ID, false)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return ContainerStats{}, fmt.Errorf("%w: %s", ErrContainerNotFound, ID)
+		}
+		return ContainerStats{}, fmt.Errorf("failed to get container stats: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return ContainerStats{}, fmt.Errorf("failed to decode container stats: %v", err)
+	}
+
+	return mapToStats(stats), nil
+}
+
+func mapToStats(stats types.StatsJSON) ContainerStats {
+	result := ContainerStats{
+		MemoryUsage: stats.MemoryStats.Usage,
+		MemoryLimit: stats.MemoryStats.Limit,
+	}
+
+	// same calculation as docker stats
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		result.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	for _, network := range stats.Networks {
+		result.NetworkRx += network.RxBytes
+		result.NetworkTx += network.TxBytes
+	}
+
+	return result
 }
 
 func mapToContainer(containerJSON types.ContainerJSON) Container {
+	var ipAddress string
+	if containerJSON.NetworkSettings != nil {
+		ipAddress = containerJSON.NetworkSettings.IPAddress
+	}
+
 	return Container{
-		ID:      containerJSON.ID,
-		Image:   containerJSON.Image,
-		Running: containerJSON.State.Running,
-		Name:    containerJSON.Name,
-		Env:     mapToEnv(containerJSON.Config.Env),
-		Volumes: mapToVolumes(containerJSON.HostConfig.Binds),
-		Ports:   mapToPorts(containerJSON.HostConfig.PortBindings),
+		ID:        containerJSON.ID,
+		Image:     containerJSON.Config.Image,
+		State:     containerJSON.State.Status,
+		Running:   containerJSON.State.Running,
+		Name:      containerJSON.Name,
+		IPAddress: ipAddress,
+		Env:       mapToEnv(containerJSON.Config.Env),
+		Volumes:   mapToVolumes(containerJSON.HostConfig.Binds),
+		Ports:     mapToPorts(containerJSON.HostConfig.PortBindings),
 	}
 }
 
+func formatEnv(env map[string]string) []string {
+	formattedEnv := make([]string, 0, len(env))
+	for key, value := range env {
+		formattedEnv = append(formattedEnv, key+"="+value)
+	}
+	return formattedEnv
+}
+
+func formatPorts(ports []PortBinding) nat.PortMap {
+	portBindings := make(nat.PortMap)
+	for _, port := range ports {
+		containerPort := nat.Port(fmt.Sprintf("%s/%s", port.Container, port.Protocol))
+		portBindings[containerPort] = append(portBindings[containerPort], nat.PortBinding{HostPort: port.Host})
+	}
+	return portBindings
+}
+
+func formatVolumes(volumes []VolumeBinding) []string {
+	binds := make([]string, len(volumes))
+	for i, volume := range volumes {
+		binds[i] = fmt.Sprintf("%s:%s", volume.Host, volume.Container)
+	}
+	return binds
+}
+
 func mapToEnv(envList []string) map[string]string {
 	obj := make(map[string]string, len(envList))
 	for _, envKeyValue := range envList {
@@ -78,15 +353,13 @@ func mapToEnv(envList []string) map[string]string {
 			continue
 		}
 
-		keyValue := strings.Split(envKeyValue, "=")
+		// values may contain "=" themselves
+		key, value, ok := strings.Cut(envKeyValue, "=")
 
-		if len(keyValue) != 2 {
+		if !ok {
 			continue
 		}
 
-		key := keyValue[0]
-		value := keyValue[1]
-
 		obj[key] = value
 	}
 	return obj
@@ -99,18 +372,16 @@ func mapToVolumes(volumesArr []string) []VolumeBinding {
 			continue
 		}
 
+		// binds are host:container with optional mode flags after another colon
 		keyValue := strings.Split(volumeStr, ":")
 
-		if len(keyValue) != 2 {
+		if len(keyValue) < 2 {
 			continue
 		}
 
-		key := keyValue[0]
-		value := keyValue[1]
-
 		volumes = append(volumes, VolumeBinding{
-			Container: key,
-			Host:      value,
+			Host:      keyValue[0],
+			Container: keyValue[1],
 		})
 	}
 	return volumes
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/rcon"
+)
+
+// RconSettings tells where a game reads its RCON port and password from in the container env
+type RconSettings struct {
+	PortEnvKey     string
+	DefaultPort    string
+	PasswordEnvKey string
+}
+
+// Keeps the RCON connection to the service container open between commands
+type rconConnection struct {
+	mu       sync.Mutex
+	address  string
+	password string
+	client   *rcon.Client
+}
+
+// Returned when the container was created without an RCON password, before the api used RCON
+var errNoRconPassword = errors.New("service container has no rcon password")
+
+// Runs a game command over RCON when the game supports it, otherwise through the strategy's exec command
+// RCON responses have no exit code, a response means the command ran.
+func (s *ServiceController) runGameCommand(c context.Context, strategy ServiceStrategy, cmd GameCommand) (ExecResult, error) {
+	if settings, err := strategy.RconSettings(); err == nil {
+		client, err := s.getRconClient(c, settings)
+		if err == nil {
+			output, err := client.Execute(cmd.Line())
+			if err != nil {
+				return ExecResult{}, fmt.Errorf("failed to run rcon command: %v", err)
+			}
+
+			return ExecResult{Output: textToLines(output)}, nil
+		}
+
+		if !errors.Is(err, errNoRconPassword) {
+			return ExecResult{}, err
+		}
+	}
+
+	execCmd, err := strategy.FormatCommand(cmd.Args)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return s.docker.Exec(c, SERVICE_CONTAINER_ID, execCmd)
+}
+
+// Returns the RCON client of the service container, reconnecting when its address or password changed
+func (s *ServiceController) getRconClient(c context.Context, settings RconSettings) (*rcon.Client, error) {
+	container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !container.Running || container.IPAddress == "" {
+		return nil, fmt.Errorf("service is not running")
+	}
+
+	port, ok := container.Env[settings.PortEnvKey]
+	if !ok || port == "" {
+		port = settings.DefaultPort
+	}
+
+	password, ok := container.Env[settings.PasswordEnvKey]
+	if !ok || password == "" {
+		return nil, errNoRconPassword
+	}
+
+	address := net.JoinHostPort(container.IPAddress, port)
+
+	s.rcon.mu.Lock()
+	defer s.rcon.mu.Unlock()
+
+	if s.rcon.client != nil && s.rcon.address == address && s.rcon.password == password {
+		return s.rcon.client, nil
+	}
+
+	if s.rcon.client != nil {
+		s.rcon.client.Close()
+	}
+
+	s.rcon.address = address
+	s.rcon.password = password
+	s.rcon.client = rcon.NewClient(address, password)
+
+	return s.rcon.client, nil
+}
+
+// Runs a game command issued by the api itself, which does not go through the command policy
+func (s *ServiceController) runInternalGameCommand(c context.Context, strategy ServiceStrategy, cmd string) (ExecResult, error) {
+	return s.runGameCommand(c, strategy, GameCommand{Args: strings.Fields(cmd)})
+}
+
+func textToLines(text string) []LogLine {
+	now := time.Now()
+
+	lines := []LogLine{}
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		lines = append(lines, LogLine{Stream: StreamStdout, Timestamp: now, Text: line})
+	}
+	return lines
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	StreamStdin  = "stdin"
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Size of the header docker puts in front of every frame of a multiplexed stream:
+// one byte for the stream, three zero bytes and the payload size as a big endian uint32
+const frameHeaderSize = 8
+
+// Stream byte of the frames carrying errors raised by the docker daemon itself
+const frameSystemErr = 3
+
+const (
+	// Largest frame accepted, docker splits its output into frames far smaller than this
+	maxFrameSize = 1 << 20
+	// Longest line kept, longer output without a line break is split into lines of this length
+	maxLineLength = 64 * 1024
+)
+
+// LogLine is a single line of container output
+type LogLine struct {
+	Stream    string    `json:"stream"`
+	Timestamp time.Time `json:"timestamp"`
+	Text      string    `json:"text"`
+}
+
+// LogDecoder reads lines from a multiplexed docker log or exec stream.
+// Frames do not line up with lines, so partial lines are kept per stream until they are complete.
+type LogDecoder struct {
+	r          io.Reader
+	timestamps bool
+	header     [frameHeaderSize]byte
+	partial    map[string]*bytes.Buffer
+	pending    []LogLine
+	done       bool
+}
+
+// Creates a decoder over a multiplexed stream.
+// Set timestamps when the stream was requested with docker timestamps, so they are parsed off the lines.
+func NewLogDecoder(r io.Reader, timestamps bool) *LogDecoder {
+	return &LogDecoder{
+		r:          r,
+		timestamps: timestamps,
+		partial: map[string]*bytes.Buffer{
+			StreamStdout: {},
+			StreamStderr: {},
+			StreamStdin:  {},
+		},
+	}
+}
+
+// Returns the next complete line, or io.EOF once the stream ended and every line was returned
+func (d *LogDecoder) Next() (LogLine, error) {
+	for len(d.pending) == 0 {
+		if d.done {
+			return LogLine{}, io.EOF
+		}
+
+		if err := d.readFrame(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return LogLine{}, err
+			}
+
+			d.done = true
+			d.flush()
+		}
+	}
+
+	line := d.pending[0]
+	d.pending = d.pending[1:]
+	return line, nil
+}
+
+// Reads the whole stream and returns all of its lines
+func DecodeLogs(r io.Reader, timestamps bool) ([]LogLine, error) {
+	decoder := NewLogDecoder(r, timestamps)
+
+	lines := []LogLine{}
+	for {
+		line, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+// Returns the text of every line
+func LineTexts(lines []LogLine) []string {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return texts
+}
+
+// Joins the text of the lines back into a single output
+func JoinLines(lines []LogLine) string {
+	return strings.Join(LineTexts(lines), "\n")
+}
+
+func (d *LogDecoder) readFrame() error {
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("truncated frame header")
+		}
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(d.header[4:])
+	if size > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the limit of %d bytes", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(d.r, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("truncated frame payload: expected %d bytes", size)
+		}
+		return err
+	}
+
+	var stream string
+	switch d.header[0] {
+	case 0:
+		stream = StreamStdin
+	case 1:
+		stream = StreamStdout
+	case 2:
+		stream = StreamStderr
+	case frameSystemErr:
+		return fmt.Errorf("docker error: %s", payload)
+	default:
+		return fmt.Errorf("unknown stream in frame header: %d", d.header[0])
+	}
+
+	buf := d.partial[stream]
+	buf.Write(payload)
+
+	for {
+		i := bytes.IndexByte(buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		d.pending = append(d.pending, d.parseLine(stream, buf.Next(i+1)))
+	}
+
+	for buf.Len() >= maxLineLength {
+		d.pending = append(d.pending, d.parseLine(stream, buf.Next(maxLineLength)))
+	}
+
+	return nil
+}
+
+// Turns unterminated last lines into complete lines once the stream ended
+func (d *LogDecoder) flush() {
+	for _, stream := range []string{StreamStdout, StreamStderr, StreamStdin} {
+		buf := d.partial[stream]
+		if buf.Len() > 0 {
+			d.pending = append(d.pending, d.parseLine(stream, buf.Bytes()))
+			buf.Reset()
+		}
+	}
+}
+
+func (d *LogDecoder) parseLine(stream string, raw []byte) LogLine {
+	text := strings.TrimRight(string(raw), "\r\n")
+	line := LogLine{Stream: stream, Text: text}
+
+	if !d.timestamps {
+		return line
+	}
+
+	// docker puts an RFC3339 timestamp and a space in front of every line
+	timestamp, rest, found := strings.Cut(text, " ")
+	if !found {
+		timestamp, rest = text, ""
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		line.Timestamp = t
+		line.Text = rest
+	}
+
+	return line
+}
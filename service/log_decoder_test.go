@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Builds a frame of a multiplexed docker stream
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, frameHeaderSize)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func frames(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+func TestDecodeLogs(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  []byte
+		want    []LogLine
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			stream: nil,
+			want:   []LogLine{},
+		},
+		{
+			name:   "one frame with lines",
+			stream: frame(1, "first\nsecond\n"),
+			want:   []LogLine{{Stream: StreamStdout, Text: "first"}, {Stream: StreamStdout, Text: "second"}},
+		},
+		{
+			name:   "line split across frames",
+			stream: frames(frame(1, "fir"), frame(1, "st\nsec"), frame(1, "ond\n")),
+			want:   []LogLine{{Stream: StreamStdout, Text: "first"}, {Stream: StreamStdout, Text: "second"}},
+		},
+		{
+			name:   "interleaved stdout and stderr",
+			stream: frames(frame(1, "out "), frame(2, "err\n"), frame(1, "line\n")),
+			want:   []LogLine{{Stream: StreamStderr, Text: "err"}, {Stream: StreamStdout, Text: "out line"}},
+		},
+		{
+			name:   "partial trailing line",
+			stream: frames(frame(1, "done\nprompt> ")),
+			want:   []LogLine{{Stream: StreamStdout, Text: "done"}, {Stream: StreamStdout, Text: "prompt> "}},
+		},
+		{
+			name:   "carriage returns",
+			stream: frame(1, "windows\r\n"),
+			want:   []LogLine{{Stream: StreamStdout, Text: "windows"}},
+		},
+		{
+			name:    "truncated header",
+			stream:  frames(frame(1, "first\n"), []byte{1, 0, 0}),
+			want:    []LogLine{{Stream: StreamStdout, Text: "first"}},
+			wantErr: true,
+		},
+		{
+			name:    "truncated payload",
+			stream:  frame(1, "first\n")[:frameHeaderSize+3],
+			want:    []LogLine{},
+			wantErr: true,
+		},
+		{
+			name:    "unknown stream",
+			stream:  frame(7, "first\n"),
+			want:    []LogLine{},
+			wantErr: true,
+		},
+		{
+			name:    "docker error",
+			stream:  frame(frameSystemErr, "no such container"),
+			want:    []LogLine{},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeLogs(bytes.NewReader(test.stream), false)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("DecodeLogs error = %v, wantErr %v", err, test.wantErr)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("DecodeLogs = %+v, want %+v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("line %d = %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeLogsTimestamps(t *testing.T) {
+	got, err := DecodeLogs(bytes.NewReader(frame(1, "2024-05-01T10:00:00.5Z Server started\n")), true)
+	if err != nil {
+		t.Fatalf("DecodeLogs: %v", err)
+	}
+
+	want := time.Date(2024, 5, 1, 10, 0, 0, 500000000, time.UTC)
+	if len(got) != 1 || got[0].Text != "Server started" || !got[0].Timestamp.Equal(want) {
+		t.Fatalf("DecodeLogs = %+v, want the line at %v", got, want)
+	}
+}
+
+func TestDecodeLogsRejectsOversizedFrame(t *testing.T) {
+	header := make([]byte, frameHeaderSize)
+	header[0] = 1
+	binary.BigEndian.PutUint32(header[4:], maxFrameSize+1)
+
+	if _, err := DecodeLogs(bytes.NewReader(header), false); err == nil {
+		t.Fatal("DecodeLogs accepted a frame over the limit")
+	}
+}
+
+func TestDecodeLogsSplitsLongPartialLines(t *testing.T) {
+	long := strings.Repeat("a", maxLineLength+10)
+
+	got, err := DecodeLogs(bytes.NewReader(frames(frame(1, long), frame(1, "\n"))), false)
+	if err != nil {
+		t.Fatalf("DecodeLogs: %v", err)
+	}
+	if len(got) != 2 || len(got[0].Text) != maxLineLength || len(got[1].Text) != 10 {
+		t.Fatalf("got %d lines, want a line of %d and a line of 10", len(got), maxLineLength)
+	}
+}
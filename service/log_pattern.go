@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"regexp"
+)
+
+// EventType tells what happened in the game, based on its console output
+type EventType string
+
+const (
+	EventPlayerJoined EventType = "playerJoined"
+	EventPlayerLeft   EventType = "playerLeft"
+	EventServerReady  EventType = "serverReady"
+	EventCrashed      EventType = "crashed"
+	// Not published, ties the session of a connection to the player whose character is logged next
+	EventPlayerConnected EventType = "playerConnected"
+)
+
+// LogPattern turns console lines matching the regex into events of the given type.
+// A named group "player" in the regex is attached to the event.
+// A named group "session" identifies the connection, for games that log players leaving by connection only.
+type LogPattern struct {
+	Event EventType
+	Regex *regexp.Regexp
+}
+
+// Gets the log patterns of the current service
+func (s *ServiceController) LogPatterns(c context.Context) ([]LogPattern, error) {
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return nil, err
+	}
+	return (*strategy).LogPatterns(), nil
+}
@@ -1,16 +1,89 @@
 package service
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Matches the output of the list command, e.g. "There are 2 of a max of 20 players online: Steve, Alex"
+var minecraftPlayerCountRegex = regexp.MustCompile(`There are (\d+) of a max of \d+ players online`)
+
+// Player names, selectors, coordinates, items and other plain arguments
+var minecraftArgPattern = regexp.MustCompile(`^[A-Za-z0-9_@~^.:,=+\-\[\]{}#!*]+$`)
+
+// Lines look like "[12:00:00] [Server thread/INFO]: Steve joined the game"
+var minecraftLogPatterns = []LogPattern{
+	{Event: EventPlayerJoined, Regex: regexp.MustCompile(`\]: (?P<player>[A-Za-z0-9_]{1,16}) joined the game`)},
+	{Event: EventPlayerLeft, Regex: regexp.MustCompile(`\]: (?P<player>[A-Za-z0-9_]{1,16}) left the game`)},
+	{Event: EventServerReady, Regex: regexp.MustCompile(`\]: Done \([0-9.]+s\)! For help, type "help"`)},
+	// a crash prints one of these once, followed by the stack trace
+	{Event: EventCrashed, Regex: regexp.MustCompile(`Encountered an unexpected exception|Exception in server tick loop|This crash report has been saved to|java\.lang\.OutOfMemoryError`)},
+}
 
-	"github.com/mooncorn/gshub-server-api/cycles"
+var minecraftCommandPolicy = CommandPolicy{
+	Allowed: map[string]CommandRule{
+		// messages, any text is fine
+		"say":  {MinArgs: 1, MaxArgs: -1},
+		"me":   {MinArgs: 1, MaxArgs: -1},
+		"tell": {MinArgs: 2, MaxArgs: -1},
+		"msg":  {MinArgs: 2, MaxArgs: -1},
+		"w":    {MinArgs: 2, MaxArgs: -1},
+		// moderation, the reason is free text
+		"kick":      {MinArgs: 1, MaxArgs: -1},
+		"ban":       {MinArgs: 1, MaxArgs: -1},
+		"ban-ip":    {MinArgs: 1, MaxArgs: -1},
+		"pardon":    {MinArgs: 1, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"pardon-ip": {MinArgs: 1, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"banlist":   {MinArgs: 0, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"whitelist": {MinArgs: 1, MaxArgs: 2, ArgPattern: minecraftArgPattern},
+		"op":        {MinArgs: 1, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"deop":      {MinArgs: 1, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"list":      {MinArgs: 0, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		// world
+		"save-all":        {MinArgs: 0, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"save-on":         {MinArgs: 0, MaxArgs: 0},
+		"save-off":        {MinArgs: 0, MaxArgs: 0},
+		"seed":            {MinArgs: 0, MaxArgs: 0},
+		"time":            {MinArgs: 1, MaxArgs: 2, ArgPattern: minecraftArgPattern},
+		"weather":         {MinArgs: 1, MaxArgs: 2, ArgPattern: minecraftArgPattern},
+		"difficulty":      {MinArgs: 0, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"gamerule":        {MinArgs: 1, MaxArgs: 2, ArgPattern: minecraftArgPattern},
+		"setworldspawn":   {MinArgs: 0, MaxArgs: 4, ArgPattern: minecraftArgPattern},
+		"defaultgamemode": {MinArgs: 1, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		// players
+		"gamemode":   {MinArgs: 1, MaxArgs: 2, ArgPattern: minecraftArgPattern},
+		"tp":         {MinArgs: 1, MaxArgs: 6, ArgPattern: minecraftArgPattern},
+		"teleport":   {MinArgs: 1, MaxArgs: 6, ArgPattern: minecraftArgPattern},
+		"give":       {MinArgs: 2, MaxArgs: 3, ArgPattern: minecraftArgPattern},
+		"clear":      {MinArgs: 0, MaxArgs: 3, ArgPattern: minecraftArgPattern},
+		"kill":       {MinArgs: 0, MaxArgs: 1, ArgPattern: minecraftArgPattern},
+		"effect":     {MinArgs: 2, MaxArgs: 6, ArgPattern: minecraftArgPattern},
+		"enchant":    {MinArgs: 2, MaxArgs: 3, ArgPattern: minecraftArgPattern},
+		"xp":         {MinArgs: 2, MaxArgs: 4, ArgPattern: minecraftArgPattern},
+		"experience": {MinArgs: 2, MaxArgs: 4, ArgPattern: minecraftArgPattern},
+		"spawnpoint": {MinArgs: 0, MaxArgs: 5, ArgPattern: minecraftArgPattern},
+	},
+	// the instance api manages the server process itself
+	Denied: []string{"stop", "restart", "reload", "debug", "perf", "jfr"},
+}
+
+// Server types of the image that load plugins and those that load mods
+var (
+	minecraftPluginTypes = []string{"PAPER", "SPIGOT", "BUKKIT", "PURPUR", "FOLIA", "PUFFERFISH"}
+	minecraftModTypes    = []string{"FABRIC", "FORGE", "NEOFORGE", "QUILT"}
 )
 
 type MinecraftServiceStrategy struct {
-	data *cycles.ServiceData
+	data *InstanceData
 }
 
-func NewMinecraftServiceStrategy(data *cycles.ServiceData) *MinecraftServiceStrategy {
+func NewMinecraftServiceStrategy(data *InstanceData) *MinecraftServiceStrategy {
 	return &MinecraftServiceStrategy{
 		data: data,
 	}
@@ -20,11 +93,83 @@ func (s *MinecraftServiceStrategy) CreateBaseConfig() map[string]string {
 	serviceMemory := CalculateServiceMemory(s.data.InstanceMemory)
 
 	return map[string]string{
-		"MEMORY": fmt.Sprintf("%dM", serviceMemory),
-		"EULA":   "TRUE",
+		"MEMORY":        fmt.Sprintf("%dM", serviceMemory),
+		"EULA":          "TRUE",
+		"ENABLE_RCON":   "TRUE",
+		"RCON_PASSWORD": generateRconPassword(),
+	}
+}
+
+func (s *MinecraftServiceStrategy) FormatCommand(args []string) ([]string, error) {
+	return append([]string{"rcon-cli"}, args...), nil
+}
+
+func (s *MinecraftServiceStrategy) CommandPolicy() CommandPolicy {
+	return minecraftCommandPolicy
+}
+
+func (s *MinecraftServiceStrategy) RconSettings() (RconSettings, error) {
+	return RconSettings{
+		PortEnvKey:     "RCON_PORT",
+		DefaultPort:    "25575",
+		PasswordEnvKey: "RCON_PASSWORD",
+	}, nil
+}
+
+func (s *MinecraftServiceStrategy) FormatBroadcast(message string) (string, error) {
+	return fmt.Sprintf("say %s", message), nil
+}
+
+func (s *MinecraftServiceStrategy) SaveCommands() []string {
+	return []string{"save-all flush"}
+}
+
+// Autosaving is turned off so the world does not change while it is archived
+func (s *MinecraftServiceStrategy) PreBackupCommands() []string {
+	return []string{"save-off", "save-all flush"}
+}
+
+func (s *MinecraftServiceStrategy) PostBackupCommands() []string {
+	return []string{"save-on"}
+}
+
+func (s *MinecraftServiceStrategy) StopTimeout() time.Duration {
+	return 60 * time.Second
+}
+
+func (s *MinecraftServiceStrategy) FormatPlayerCountCommand() (string, error) {
+	return "list", nil
+}
+
+func (s *MinecraftServiceStrategy) ParsePlayerCount(output string) (int, error) {
+	match := minecraftPlayerCountRegex.FindStringSubmatch(output)
+	if match == nil {
+		return 0, fmt.Errorf("unexpected list output: %s", output)
+	}
+	return strconv.Atoi(match[1])
+}
+
+func (s *MinecraftServiceStrategy) LogPatterns() []LogPattern {
+	return minecraftLogPatterns
+}
+
+// Plugin servers load the jars in /data/plugins and mod loaders those in /data/mods, vanilla servers load neither
+func (s *MinecraftServiceStrategy) ModLayout(env map[string]string) (ModLayout, error) {
+	serverType := strings.ToUpper(env["TYPE"])
+
+	switch {
+	case slices.Contains(minecraftPluginTypes, serverType):
+		return ModLayout{Dir: "/data/plugins", DisabledDir: "/data/plugins-disabled", Extension: ".jar"}, nil
+	case slices.Contains(minecraftModTypes, serverType):
+		return ModLayout{Dir: "/data/mods", DisabledDir: "/data/mods-disabled", Extension: ".jar"}, nil
+	default:
+		return ModLayout{}, ErrNotSupported
 	}
 }
 
-func (s *MinecraftServiceStrategy) FormatCommand(cmd string) (string, error) {
-	return fmt.Sprintf("rcon-cli %s", cmd), nil
+// The RCON port is only reachable from the instance, a random password keeps other containers out
+func generateRconPassword() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
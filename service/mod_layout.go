@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+)
+
+// Where the game loads mods or plugins from, as paths inside the container
+type ModLayout struct {
+	// Directory the game loads mods from
+	Dir string `json:"dir"`
+	// Directory disabled mods are moved to, the game does not look into it
+	DisabledDir string `json:"disabledDir"`
+	// File extension of a mod, such as .jar
+	Extension string `json:"extension"`
+}
+
+// Gets the mod layout of the current service, ErrNotSupported when the game or its server type has no mods
+func (s *ServiceController) ModLayout(c context.Context) (ModLayout, error) {
+	container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+	if err != nil {
+		return ModLayout{}, err
+	}
+
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return ModLayout{}, err
+	}
+
+	return (*strategy).ModLayout(container.Env)
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
+	"time"
 
-	coreConfig "github.com/mooncorn/gshub-core/config"
 	"github.com/mooncorn/gshub-server-api/internal"
 )
 
@@ -16,60 +18,189 @@ type InstanceData struct {
 }
 
 type ServiceController struct {
-	docker         *DockerClient
+	docker         DockerClient
 	data           *InstanceData
 	serviceFactory ServiceStrategyFactory
+	rcon           rconConnection
 }
 
 const SERVICE_CONTAINER_ID = "main"
 
+var (
+	// Returned when a service is created while the instance already has one
+	ErrServiceExists = errors.New("a service already exists on this instance")
+	// Returned when the config of a service is not valid
+	ErrInvalidConfig = errors.New("invalid server configuration")
+	// Returned when the instance does not have enough memory for the service
+	ErrNotEnoughMemory = fmt.Errorf("%w: this instance does not meet minimum memory requirements for this service", ErrInvalidConfig)
+)
+
 func NewServiceController(data *InstanceData) (*ServiceController, error) {
 	docker, err := NewDockerClient()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create docker container: %v", err)
+		return nil, fmt.Errorf("failed to create docker client: %v", err)
 	}
 
+	return NewServiceControllerWithClient(data, docker), nil
 }
 
-// check for existing container and return appropriate strategy for it
-func (s *ServiceController) getStrategy(c context.Context) (*ServiceStrategy, error) {
-	container, err := s.docker.GetContainer(c, "main")
+// Creates a service controller on top of the given docker client, such as the fake of the servicetest package
+func NewServiceControllerWithClient(data *InstanceData, docker DockerClient) *ServiceController {
+	return &ServiceController{
+		docker:         docker,
+		data:           data,
+		serviceFactory: NewServiceFactory(data),
+	}
+}
+
+// Gets the configuration of the service the container was created for, based on its image
+func (s *ServiceController) getServiceConfig(c context.Context) (internal.ServiceConfiguration, error) {
+	container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get main container: %v", err)
+		return internal.ServiceConfiguration{}, fmt.Errorf("failed to get main container: %w", err)
 	}
 
 	// find serviceNameID using image from container
 	for _, conf := range s.data.ServiceConfigs {
 		if strings.EqualFold(conf.Image, container.Image) {
-			return s.serviceFactory.CreateService(conf.Name)
+			return conf, nil
 		}
 	}
 
-	return nil, fmt.Errorf("no strategy found for this container image: %s", container.Image)
+	return internal.ServiceConfiguration{}, fmt.Errorf("no service found for this container image: %s", container.Image)
+}
+
+// check for existing container and return appropriate strategy for it
+func (s *ServiceController) getStrategy(c context.Context) (*ServiceStrategy, error) {
+	conf, err := s.getServiceConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.serviceFactory.CreateService(conf.Name)
 }
 
-func (s *ServiceController) CreateService(c context.Context, serviceNameID string, serviceEnv map[string]string) (*Container, error) {
+// Creates the service container from the validated config, pulling the image of the service when needed
+func (s *ServiceController) CreateService(c context.Context, serviceNameID string, serviceEnv map[string]string) (Container, error) {
 	// check if there's already a service created
 	if _, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID); err == nil {
-		return nil, fmt.Errorf("conflict: a service already exists on this instance")
+		return Container{}, ErrServiceExists
+	} else if !errors.Is(err, ErrContainerNotFound) {
+		return Container{}, err
+	}
+
+	env, err := s.ValidateConfig(serviceNameID, serviceEnv)
+	if err != nil {
+		return Container{}, err
+	}
+
+	conf := s.data.ServiceConfigs[serviceNameID]
+
+	if err := s.docker.PullImage(c, conf.Image); err != nil {
+		return Container{}, err
+	}
+
+	if _, err := s.docker.CreateContainer(c, SERVICE_CONTAINER_ID, ContainerConfig{
+		Image:     conf.Image,
+		Env:       env,
+		Ports:     toPortBindings(conf.Ports),
+		Volumes:   toVolumeBindings(conf.Volumes),
+		OpenStdin: true,
+	}); err != nil {
+		return Container{}, err
+	}
+
+	return s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+}
+
+// Gets the service container
+func (s *ServiceController) GetService(c context.Context) (Container, error) {
+	return s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+}
+
+// Gets the values of the service env that users can configure
+func (s *ServiceController) GetEnv(c context.Context) (map[string]string, error) {
+	container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := s.getServiceConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter out unwanted env vars
+	values := make(map[string]string)
+	for _, env := range conf.Env {
+		if value, ok := container.Env[env.Key]; ok {
+			values[env.Key] = value
+		}
+	}
+	return values, nil
+}
+
+func (s *ServiceController) StartService(c context.Context) error {
+	if _, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID); err != nil {
+		return err
+	}
+	return s.docker.StartContainer(c, SERVICE_CONTAINER_ID)
+}
+
+// Saves the game and stops it, see GracefulStop
+func (s *ServiceController) StopService(c context.Context) error {
+	if _, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID); err != nil {
+		return err
+	}
+	return s.GracefulStop(c)
+}
+
+// Saves and stops the game, then starts it again
+func (s *ServiceController) RestartService(c context.Context) error {
+	if err := s.StopService(c); err != nil {
+		return err
 	}
+	return s.docker.StartContainer(c, SERVICE_CONTAINER_ID)
+}
 
+// Stops the game and removes its container, the game files on the host are kept
+func (s *ServiceController) RemoveService(c context.Context) error {
+	if err := s.StopService(c); err != nil {
+		return err
+	}
+	return s.docker.RemoveContainer(c, SERVICE_CONTAINER_ID)
 }
 
-func (v *ServiceController) ValidateConfig(config map[string]string) (map[string]string, error) {
+// Runs a program inside the service container, without a shell
+func (s *ServiceController) Exec(c context.Context, cmd []string) (ExecResult, error) {
+	return s.docker.Exec(c, SERVICE_CONTAINER_ID, cmd)
+}
+
+// Fills in the defaults and the base config of the service and checks the given values against the allowed ones
+func (s *ServiceController) ValidateConfig(serviceNameID string, config map[string]string) (map[string]string, error) {
+	conf, ok := s.data.ServiceConfigs[serviceNameID]
+	if !ok {
+		return nil, fmt.Errorf("%w: service not found: %s", ErrInvalidConfig, serviceNameID)
+	}
+
 	// Verify if the plan can accommodate this type of service
-	if !v.hasEnoughMemory() {
-		return nil, errors.New("not supported: this instance does not meet minimum memory requirements for this service")
+	if !s.hasEnoughMemory(conf) {
+		return nil, ErrNotEnoughMemory
+	}
+
+	strategy, err := s.serviceFactory.CreateService(serviceNameID)
+	if err != nil {
+		return nil, err
 	}
 
-	baseConfig := v.createBaseConfig()
+	baseConfig := (*strategy).CreateBaseConfig()
 
-	for _, env := range v.config.Env {
+	for _, env := range conf.Env {
 		value, ok := config[env.Key]
 
 		if !ok {
 			if env.Required {
-				return nil, fmt.Errorf("%s is required", env.Key)
+				return nil, fmt.Errorf("%w: %s is required", ErrInvalidConfig, env.Key)
 			}
 
 			baseConfig[env.Key] = env.Default
@@ -77,7 +208,7 @@ func (v *ServiceController) ValidateConfig(config map[string]string) (map[string
 		}
 
 		if !isValidConfigValue(env.Values, value) {
-			return nil, fmt.Errorf("invalid %s: %s", env.Key, value)
+			return nil, fmt.Errorf("%w: invalid %s: %s", ErrInvalidConfig, env.Key, value)
 		}
 
 		baseConfig[env.Key] = value
@@ -86,17 +217,136 @@ func (v *ServiceController) ValidateConfig(config map[string]string) (map[string
 	return baseConfig, nil
 }
 
-// ExecuteStrategy executes the current strategy
-func (v *ServiceController) createBaseConfig() map[string]string {
-	return v.strategy.CreateBaseConfig()
+// Checks a user command against the command policy without running it.
+// ErrNotSupported is returned when the game can not run commands at all.
+func (s *ServiceController) CheckCommand(c context.Context, cmd string) error {
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return err
+	}
+
+	command, err := (*strategy).CommandPolicy().Check(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := (*strategy).RconSettings(); err == nil {
+		return nil
+	}
+	_, err = (*strategy).FormatCommand(command.Args)
+	return err
 }
 
-func (v *ServiceController) FormatGameCommand(cmd string) (string, error) {
-	return v.strategy.FormatCommand(cmd)
+// Runs a user command in the service container and returns its output.
+// The command is rejected with a PolicyError when the command policy of the game does not allow it.
+func (s *ServiceController) RunCommand(c context.Context, cmd string) (ExecResult, error) {
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	command, err := (*strategy).CommandPolicy().Check(cmd)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return s.runGameCommand(c, *strategy, command)
+}
+
+// Shows a message to everyone in game
+func (s *ServiceController) Broadcast(c context.Context, message string) error {
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return err
+	}
+
+	cmd, err := (*strategy).FormatBroadcast(message)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.runInternalGameCommand(c, *strategy, cmd); err != nil {
+		return fmt.Errorf("failed to broadcast message: %v", err)
+	}
+
+	return nil
+}
+
+// Saves the game and stops the service container, waiting until it has exited
+func (s *ServiceController) GracefulStop(c context.Context) error {
+	container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+	if errors.Is(err, ErrContainerNotFound) {
+		// nothing to stop
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !container.Running {
+		return nil
+	}
+
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range (*strategy).SaveCommands() {
+		if _, err := s.runInternalGameCommand(c, *strategy, cmd); err != nil {
+			log.Printf("Failed to save game state: %v", err)
+		}
+	}
+
+	if err := s.docker.StopContainer(c, SERVICE_CONTAINER_ID, (*strategy).StopTimeout()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Checks if the service container exists and is running
+func (s *ServiceController) IsRunning(c context.Context) (bool, error) {
+	container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+	if err != nil {
+		if errors.Is(err, ErrContainerNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return container.Running, nil
+}
+
+// Gets the number of players connected to the game
+func (s *ServiceController) PlayerCount(c context.Context) (int, error) {
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return 0, err
+	}
+
+	cmd, err := (*strategy).FormatPlayerCountCommand()
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := s.runInternalGameCommand(c, *strategy, cmd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get player count: %v", err)
+	}
+
+	return (*strategy).ParsePlayerCount(JoinLines(output.Output))
+}
+
+// Returns how long a graceful stop of the current service can take
+func (s *ServiceController) StopTimeout(c context.Context) time.Duration {
+	strategy, err := s.getStrategy(c)
+	if err != nil {
+		return 10 * time.Second
+	}
+	return (*strategy).StopTimeout()
 }
 
 // Check if the provided value is one of the allowed values.
-func isValidConfigValue(values []coreConfig.Value, value string) bool {
+func isValidConfigValue(values []internal.Value, value string) bool {
 	for _, envValue := range values {
 		if envValue.Value == value {
 			return true
@@ -105,10 +355,43 @@ func isValidConfigValue(values []coreConfig.Value, value string) bool {
 	return false
 }
 
+// Finds the setting with the given key
+func findEnv(envs []internal.Env, key string) (internal.Env, bool) {
+	for _, env := range envs {
+		if env.Key == key {
+			return env, true
+		}
+	}
+	return internal.Env{}, false
+}
+
 func CalculateServiceMemory(instanceMemoryMB int) int {
 	return instanceMemoryMB - 1024 // 1GB allocated for the system and api
 }
 
-func (c *ServiceController) hasEnoughMemory() bool {
-	return c.data.ServiceMinimumMemoryRequired <= CalculateServiceMemory(c.data.InstanceMemory)
+func (s *ServiceController) hasEnoughMemory(conf internal.ServiceConfiguration) bool {
+	return conf.MinMem <= CalculateServiceMemory(s.data.InstanceMemory)
+}
+
+func toPortBindings(ports []internal.Port) []PortBinding {
+	bindings := make([]PortBinding, len(ports))
+	for i, port := range ports {
+		bindings[i] = PortBinding{
+			Container: strconv.FormatInt(port.Container, 10),
+			Host:      strconv.FormatInt(port.Host, 10),
+			Protocol:  port.Protocol,
+		}
+	}
+	return bindings
+}
+
+func toVolumeBindings(volumes []internal.Volume) []VolumeBinding {
+	bindings := make([]VolumeBinding, len(volumes))
+	for i, volume := range volumes {
+		bindings[i] = VolumeBinding{
+			Container: volume.Destination,
+			Host:      volume.Host,
+		}
+	}
+	return bindings
 }
@@ -0,0 +1,316 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mooncorn/gshub-server-api/internal"
+	"github.com/mooncorn/gshub-server-api/service"
+	"github.com/mooncorn/gshub-server-api/service/servicetest"
+)
+
+const (
+	minecraftImage = "itzg/minecraft-server"
+	valheimImage   = "lloesche/valheim-server"
+)
+
+func newController(t *testing.T) (*service.ServiceController, *servicetest.FakeDockerClient) {
+	t.Helper()
+
+	docker := servicetest.NewFakeDockerClient()
+	data := &service.InstanceData{
+		InstanceID: "instance",
+		StartupPayload: internal.StartupPayload{
+			InstanceMemory: 4096,
+			ServiceConfigs: map[string]internal.ServiceConfiguration{
+				"minecraft": {Name: "minecraft", Image: minecraftImage, MinMem: 1024},
+				"valheim": {
+					Name:   "valheim",
+					Image:  valheimImage,
+					MinMem: 2048,
+					Env: []internal.Env{
+						{Key: "PUBLIC", Default: "1", Values: []internal.Value{{Value: "0"}, {Value: "1"}}},
+					},
+				},
+			},
+		},
+	}
+
+	return service.NewServiceControllerWithClient(data, docker), docker
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	ctx := context.Background()
+	controller, docker := newController(t)
+
+	container, err := controller.CreateService(ctx, "valheim", map[string]string{"PUBLIC": "0"})
+	if err != nil {
+		t.Fatalf("CreateService: %v", err)
+	}
+	if container.Running || container.Env["PUBLIC"] != "0" {
+		t.Fatalf("created container = %+v, want a stopped container with PUBLIC=0", container)
+	}
+	if !slices.Equal(docker.Pulls, []string{valheimImage}) {
+		t.Fatalf("pulled %v, want the valheim image", docker.Pulls)
+	}
+
+	if _, err := controller.CreateService(ctx, "valheim", nil); !errors.Is(err, service.ErrServiceExists) {
+		t.Fatalf("second CreateService error = %v, want ErrServiceExists", err)
+	}
+
+	steps := []struct {
+		name    string
+		run     func(context.Context) error
+		running bool
+	}{
+		{"start", controller.StartService, true},
+		{"restart", controller.RestartService, true},
+		{"stop", controller.StopService, false},
+		{"stop again", controller.StopService, false},
+		{"start again", controller.StartService, true},
+	}
+
+	for _, step := range steps {
+		if err := step.run(ctx); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		running, err := controller.IsRunning(ctx)
+		if err != nil {
+			t.Fatalf("%s: IsRunning: %v", step.name, err)
+		}
+		if running != step.running {
+			t.Fatalf("after %s running = %v, want %v", step.name, running, step.running)
+		}
+	}
+
+	if err := controller.RemoveService(ctx); err != nil {
+		t.Fatalf("RemoveService: %v", err)
+	}
+	if _, err := controller.GetService(ctx); !errors.Is(err, service.ErrContainerNotFound) {
+		t.Fatalf("GetService after remove error = %v, want ErrContainerNotFound", err)
+	}
+}
+
+func TestServiceFailedStart(t *testing.T) {
+	ctx := context.Background()
+	controller, docker := newController(t)
+	docker.StartHandler = func(container service.Container) error { return errors.New("port is already allocated") }
+
+	if _, err := controller.CreateService(ctx, "valheim", nil); err != nil {
+		t.Fatalf("CreateService: %v", err)
+	}
+	if err := controller.StartService(ctx); err == nil {
+		t.Fatal("StartService succeeded although the container did not start")
+	}
+}
+
+func TestServiceMissingContainer(t *testing.T) {
+	ctx := context.Background()
+	controller, _ := newController(t)
+
+	tests := []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		{"start", controller.StartService},
+		{"stop", controller.StopService},
+		{"restart", controller.RestartService},
+		{"remove", controller.RemoveService},
+		{"get", func(c context.Context) error { _, err := controller.GetService(c); return err }},
+		{"env", func(c context.Context) error { _, err := controller.GetEnv(c); return err }},
+		{"command", func(c context.Context) error { _, err := controller.RunCommand(c, "list"); return err }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.run(ctx); !errors.Is(err, service.ErrContainerNotFound) {
+				t.Fatalf("error = %v, want ErrContainerNotFound", err)
+			}
+		})
+	}
+
+	// there is nothing to stop, which is not an error when the api shuts down
+	if err := controller.GracefulStop(ctx); err != nil {
+		t.Fatalf("GracefulStop: %v", err)
+	}
+	if running, err := controller.IsRunning(ctx); err != nil || running {
+		t.Fatalf("IsRunning = %v, %v, want false", running, err)
+	}
+}
+
+func TestServiceInvalidConfig(t *testing.T) {
+	ctx := context.Background()
+	controller, _ := newController(t)
+
+	tests := []struct {
+		name      string
+		serviceID string
+		env       map[string]string
+	}{
+		{"unknown service", "terraria", nil},
+		{"value not allowed", "valheim", map[string]string{"PUBLIC": "maybe"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := controller.CreateService(ctx, test.serviceID, test.env); !errors.Is(err, service.ErrInvalidConfig) {
+				t.Fatalf("CreateService error = %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+func TestServiceCommandWithoutRconPassword(t *testing.T) {
+	ctx := context.Background()
+	controller, docker := newController(t)
+
+	// containers created before commands were sent over RCON have no password in their env
+	docker.AddImage(minecraftImage)
+	if _, err := docker.CreateContainer(ctx, service.SERVICE_CONTAINER_ID, service.ContainerConfig{Image: minecraftImage, Env: map[string]string{"EULA": "TRUE"}}); err != nil {
+		t.Fatalf("CreateContainer: %v", err)
+	}
+	if err := controller.StartService(ctx); err != nil {
+		t.Fatalf("StartService: %v", err)
+	}
+
+	if _, err := controller.RunCommand(ctx, "/time set day"); err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if len(docker.Execs) != 1 || !slices.Equal(docker.Execs[0], []string{"rcon-cli", "time", "set", "day"}) {
+		t.Fatalf("exec = %v, want rcon-cli with the checked arguments", docker.Execs)
+	}
+
+	// the game is saved over the same path before it stops
+	if err := controller.StopService(ctx); err != nil {
+		t.Fatalf("StopService: %v", err)
+	}
+	if len(docker.Execs) != 2 || !slices.Equal(docker.Execs[1], []string{"rcon-cli", "save-all", "flush"}) {
+		t.Fatalf("exec = %v, want the game saved before it stops", docker.Execs)
+	}
+}
+
+func TestServiceCommandRejected(t *testing.T) {
+	ctx := context.Background()
+	controller, docker := newController(t)
+
+	docker.AddImage(minecraftImage)
+	if _, err := docker.CreateContainer(ctx, service.SERVICE_CONTAINER_ID, service.ContainerConfig{Image: minecraftImage}); err != nil {
+		t.Fatalf("CreateContainer: %v", err)
+	}
+	if err := controller.StartService(ctx); err != nil {
+		t.Fatalf("StartService: %v", err)
+	}
+
+	var policyErr *service.PolicyError
+	if _, err := controller.RunCommand(ctx, "stop"); !errors.As(err, &policyErr) {
+		t.Fatalf("RunCommand error = %v, want a PolicyError", err)
+	}
+	if err := controller.CheckCommand(ctx, "stop"); !errors.As(err, &policyErr) {
+		t.Fatalf("CheckCommand error = %v, want a PolicyError", err)
+	}
+	if err := controller.CheckCommand(ctx, "time set day"); err != nil {
+		t.Fatalf("CheckCommand of an allowed command: %v", err)
+	}
+	if len(docker.Execs) != 0 {
+		t.Fatalf("a rejected command was run: %v", docker.Execs)
+	}
+}
+
+func TestServiceFollowConsole(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	controller, docker := newController(t)
+	if _, err := controller.CreateService(ctx, "valheim", nil); err != nil {
+		t.Fatalf("CreateService: %v", err)
+	}
+	if err := controller.StartService(ctx); err != nil {
+		t.Fatalf("StartService: %v", err)
+	}
+	docker.WriteLog(service.SERVICE_CONTAINER_ID, service.StreamStdout, "Loading world")
+
+	lines, errs := controller.FollowConsole(ctx, "all", "")
+
+	next := func() service.LogLine {
+		t.Helper()
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("console ended early")
+			}
+			return line
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for a console line")
+		}
+		return service.LogLine{}
+	}
+
+	if line := next(); line.Text != "Loading world" || line.Timestamp.IsZero() {
+		t.Fatalf("first line = %+v, want the earlier line with its timestamp", line)
+	}
+
+	docker.WriteLog(service.SERVICE_CONTAINER_ID, service.StreamStderr, "Game server connected")
+	if line := next(); line.Text != "Game server connected" || line.Stream != service.StreamStderr {
+		t.Fatalf("followed line = %+v, want the new stderr line", line)
+	}
+
+	// the console ends with the game
+	docker.Exit(service.SERVICE_CONTAINER_ID)
+	for range lines {
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("FollowConsole: %v", err)
+	default:
+	}
+
+	console, err := controller.GetConsole(ctx)
+	if err != nil {
+		t.Fatalf("GetConsole: %v", err)
+	}
+	if texts := service.LineTexts(console); !slices.Equal(texts, []string{"Loading world", "Game server connected"}) {
+		t.Fatalf("console = %q", texts)
+	}
+}
+
+func TestServiceUpdate(t *testing.T) {
+	ctx := context.Background()
+	controller, docker := newController(t)
+
+	if _, err := controller.CreateService(ctx, "valheim", map[string]string{"PUBLIC": "1"}); err != nil {
+		t.Fatalf("CreateService: %v", err)
+	}
+	before, _ := controller.GetService(ctx)
+
+	changed, err := controller.UpdateService(ctx, map[string]string{"PUBLIC": "1"})
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("UpdateService without changes = %v, %v, want nothing", changed, err)
+	}
+
+	changed, err = controller.UpdateService(ctx, map[string]string{"PUBLIC": "0"})
+	if err != nil {
+		t.Fatalf("UpdateService: %v", err)
+	}
+	if !slices.Equal(changed, []string{"PUBLIC"}) {
+		t.Fatalf("changed = %v, want [PUBLIC]", changed)
+	}
+
+	after, err := controller.GetService(ctx)
+	if err != nil {
+		t.Fatalf("GetService: %v", err)
+	}
+	if after.ID == before.ID || after.Env["PUBLIC"] != "0" {
+		t.Fatalf("container = %+v, want a new container with PUBLIC=0", after)
+	}
+	if _, err := docker.GetContainer(ctx, before.ID); !errors.Is(err, service.ErrContainerNotFound) {
+		t.Fatalf("previous container was kept: %v", err)
+	}
+
+	if _, err := controller.UpdateService(ctx, map[string]string{"UNKNOWN": "1"}); !errors.Is(err, service.ErrInvalidConfig) {
+		t.Fatalf("UpdateService of an unknown setting error = %v, want ErrInvalidConfig", err)
+	}
+}
@@ -1,15 +1,43 @@
 package service
 
 import (
+	"errors"
 	"fmt"
-
-	"github.com/mooncorn/gshub-server-api/internal"
+	"time"
 )
 
+// Returned by strategies for features the game does not support
+var ErrNotSupported = errors.New("feature not supported")
+
 // Defines the interface for different strategies
+// Game commands are sent over RCON when the strategy declares RCON settings,
+// otherwise FormatCommand turns them into a program run inside the container, without a shell.
 type ServiceStrategy interface {
 	CreateBaseConfig() map[string]string
-	FormatCommand(cmd string) (string, error)
+	// Turns the arguments of a game command into the program and arguments to exec for games without RCON
+	FormatCommand(args []string) ([]string, error)
+	// Decides which game commands users are allowed to run
+	CommandPolicy() CommandPolicy
+	// Tells where the RCON port and password are found in the container env
+	RconSettings() (RconSettings, error)
+	// Formats the game command that shows a message to everyone in game
+	FormatBroadcast(message string) (string, error)
+	// Game commands that flush the game state to disk before the container is stopped
+	SaveCommands() []string
+	// Game commands that flush the game state and stop further writes while the game files are backed up
+	PreBackupCommands() []string
+	// Game commands that let the game write its files again once the backup is done
+	PostBackupCommands() []string
+	// How long the game gets to exit on its own before it is killed
+	StopTimeout() time.Duration
+	// Formats the game command whose output lists the connected players
+	FormatPlayerCountCommand() (string, error)
+	// Gets the number of connected players from the output of the player count command
+	ParsePlayerCount(output string) (int, error)
+	// Console lines that mark game events, such as players joining or the server being ready
+	LogPatterns() []LogPattern
+	// Tells where mods are loaded from for the env of the container, ErrNotSupported for games without mods
+	ModLayout(env map[string]string) (ModLayout, error)
 }
 
 type ServiceStrategyFactory interface {
@@ -17,10 +45,10 @@ type ServiceStrategyFactory interface {
 }
 
 type ServiceFactory struct {
-	data *map[string]internal.ServiceConfiguration
+	data *InstanceData
 }
 
-func NewServiceFactory(data *map[string]internal.ServiceConfiguration) *ServiceFactory {
+func NewServiceFactory(data *InstanceData) *ServiceFactory {
 	return &ServiceFactory{
 		data: data,
 	}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// Name the previous container is kept under while its replacement starts
+	previousContainerName = "main-previous"
+	// How long the new container has to keep running before the update is considered done
+	updateStartGracePeriod = 10 * time.Second
+)
+
+// Changes the env of the service, recreating its container with the same image, ports and volumes.
+// Only the settings users can configure are changed, the rest of the env is kept as is.
+// The service is started again when it was running, and put back to its previous config if it does not start.
+// Returns the keys of the settings that changed, nothing is recreated when none did.
+func (s *ServiceController) UpdateService(c context.Context, changes map[string]string) ([]string, error) {
+	current, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := s.getServiceConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string)
+	for _, env := range conf.Env {
+		if value, ok := current.Env[env.Key]; ok {
+			settings[env.Key] = value
+		}
+	}
+
+	for key, value := range changes {
+		if _, ok := findEnv(conf.Env, key); !ok {
+			return nil, fmt.Errorf("%w: unknown setting %s", ErrInvalidConfig, key)
+		}
+		settings[key] = value
+	}
+
+	validated, err := s.ValidateConfig(conf.Name, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	// generated values such as passwords and the env of the image are kept from the current container
+	env := make(map[string]string, len(current.Env))
+	for key, value := range current.Env {
+		env[key] = value
+	}
+	for key, value := range validated {
+		_, configurable := findEnv(conf.Env, key)
+		if _, ok := env[key]; configurable || !ok {
+			env[key] = value
+		}
+	}
+
+	changed := diffEnv(current.Env, env)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	if err := s.recreate(c, current, env); err != nil {
+		return nil, err
+	}
+
+	return changed, nil
+}
+
+// Replaces the service container with one using the given env, keeping the previous one until the new one runs
+func (s *ServiceController) recreate(c context.Context, current Container, env map[string]string) error {
+	// a client going away halfway through must not leave the service without a container
+	c = context.WithoutCancel(c)
+
+	if err := s.GracefulStop(c); err != nil {
+		return err
+	}
+
+	if err := s.docker.RenameContainer(c, current.ID, previousContainerName); err != nil {
+		return s.restorePrevious(c, current, err)
+	}
+
+	_, err := s.docker.CreateContainer(c, SERVICE_CONTAINER_ID, ContainerConfig{
+		Image:     current.Image,
+		Env:       env,
+		Ports:     current.Ports,
+		Volumes:   current.Volumes,
+		OpenStdin: true,
+	})
+	if err != nil {
+		return s.restorePrevious(c, current, err)
+	}
+
+	if current.Running {
+		if err := s.startAndWait(c); err != nil {
+			return s.restorePrevious(c, current, err)
+		}
+	}
+
+	if err := s.docker.RemoveContainer(c, current.ID); err != nil {
+		log.Printf("Failed to remove the previous container: %v", err)
+	}
+
+	return nil
+}
+
+// Starts the service container and checks that it is still running after the grace period
+func (s *ServiceController) startAndWait(c context.Context) error {
+	if err := s.docker.StartContainer(c, SERVICE_CONTAINER_ID); err != nil {
+		return err
+	}
+
+	select {
+	case <-c.Done():
+		return c.Err()
+	case <-time.After(updateStartGracePeriod):
+	}
+
+	container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID)
+	if err != nil {
+		return err
+	}
+
+	if !container.Running {
+		return fmt.Errorf("container exited after start: %s", container.State)
+	}
+	return nil
+}
+
+// Puts the previous container back in place after a failed update and returns the cause of the failure
+func (s *ServiceController) restorePrevious(c context.Context, previous Container, cause error) error {
+	if container, err := s.docker.GetContainer(c, SERVICE_CONTAINER_ID); err == nil && container.ID != previous.ID {
+		// the updated container is in the way
+		if err := s.docker.StopContainer(c, container.ID, updateStartGracePeriod); err != nil {
+			log.Printf("Failed to stop the updated container: %v", err)
+		}
+		if err := s.docker.RemoveContainer(c, container.ID); err != nil {
+			return fmt.Errorf("failed to update service: %v, and failed to remove the updated container: %v", cause, err)
+		}
+	}
+
+	// the previous container may never have been renamed
+	if container, err := s.docker.GetContainer(c, previous.ID); err == nil && container.Name != "/"+SERVICE_CONTAINER_ID {
+		if err := s.docker.RenameContainer(c, previous.ID, SERVICE_CONTAINER_ID); err != nil {
+			return fmt.Errorf("failed to update service: %v, and failed to restore the previous container: %v", cause, err)
+		}
+	}
+
+	if previous.Running {
+		if err := s.docker.StartContainer(c, SERVICE_CONTAINER_ID); err != nil {
+			return fmt.Errorf("failed to update service: %v, and failed to start the previous container: %v", cause, err)
+		}
+	}
+
+	return fmt.Errorf("failed to update service, the previous config was restored: %v", cause)
+}
+
+// Returns the sorted keys whose value differs between the two envs, including keys only one of them has
+func diffEnv(current map[string]string, updated map[string]string) []string {
+	var changed []string
+	for key, value := range updated {
+		if currentValue, ok := current[key]; !ok || currentValue != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range current {
+		if _, ok := updated[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
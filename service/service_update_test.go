@@ -0,0 +1,31 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		current map[string]string
+		updated map[string]string
+		want    []string
+	}{
+		{"same", map[string]string{"A": "1", "B": "2"}, map[string]string{"B": "2", "A": "1"}, nil},
+		{"both empty", nil, map[string]string{}, nil},
+		{"changed", map[string]string{"A": "1", "B": "2"}, map[string]string{"A": "1", "B": "3"}, []string{"B"}},
+		{"added", map[string]string{"A": "1"}, map[string]string{"A": "1", "C": "3"}, []string{"C"}},
+		{"removed", map[string]string{"A": "1", "B": "2"}, map[string]string{"A": "1"}, []string{"B"}},
+		{"emptied", map[string]string{"A": "1"}, map[string]string{"A": ""}, []string{"A"}},
+		{"sorted", map[string]string{"C": "1", "A": "1"}, map[string]string{"B": "1"}, []string{"A", "B", "C"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := diffEnv(test.current, test.updated); !slices.Equal(got, test.want) {
+				t.Fatalf("diffEnv = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
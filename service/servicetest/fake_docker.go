@@ -0,0 +1,484 @@
+// Package servicetest provides an in-memory docker client for testing the service controller and the handlers
+package servicetest
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/mooncorn/gshub-server-api/service"
+)
+
+// FakeDockerClient is an in-memory service.DockerClient for tests, so the service controller and the handlers
+// can run without a docker daemon.
+// Containers go through the same states as real ones and keep their output as log lines,
+// which can be read, followed and attached to like the logs of a real container.
+type FakeDockerClient struct {
+	mu         sync.Mutex
+	images     map[string]bool
+	containers map[string]*fakeContainer
+
+	// Decides whether a container starts, so failed starts can be simulated. Every container starts when it is nil
+	StartHandler func(container service.Container) error
+	// Runs the commands passed to Exec, the command is echoed back when it is nil
+	ExecHandler func(ID string, cmd []string) (service.ExecResult, error)
+	// Images pulled so far, in order
+	Pulls []string
+	// Commands passed to Exec so far, in order
+	Execs [][]string
+}
+
+var _ service.DockerClient = (*FakeDockerClient)(nil)
+
+type fakeContainer struct {
+	container service.Container
+	logs      []service.LogLine
+	stats     service.ContainerStats
+	// closed and replaced whenever a line is logged or the state changes, so followers wake up
+	changed chan struct{}
+}
+
+func NewFakeDockerClient() *FakeDockerClient {
+	return &FakeDockerClient{
+		images:     make(map[string]bool),
+		containers: make(map[string]*fakeContainer),
+	}
+}
+
+// Makes the image available without pulling it
+func (f *FakeDockerClient) AddImage(image string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[image] = true
+}
+
+// Adds a line to the output of the container, as if the game printed it
+func (f *FakeDockerClient) WriteLog(ID string, stream string, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return err
+	}
+
+	f.log(fc, stream, text)
+	return nil
+}
+
+// Sets the resource usage reported for the container
+func (f *FakeDockerClient) SetStats(ID string, stats service.ContainerStats) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return err
+	}
+
+	fc.stats = stats
+	return nil
+}
+
+// Makes the container exit on its own, as if the game crashed
+func (f *FakeDockerClient) Exit(ID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return err
+	}
+
+	f.setState(fc, "exited")
+	return nil
+}
+
+func (f *FakeDockerClient) GetContainer(c context.Context, ID string) (service.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return service.Container{}, err
+	}
+
+	return copyContainer(fc.container), nil
+}
+
+func (f *FakeDockerClient) PullImage(c context.Context, image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.images[image] {
+		f.images[image] = true
+		f.Pulls = append(f.Pulls, image)
+	}
+	return nil
+}
+
+func (f *FakeDockerClient) CreateContainer(c context.Context, name string, config service.ContainerConfig) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.images[config.Image] {
+		return "", fmt.Errorf("failed to create container: no such image: %s", config.Image)
+	}
+
+	if _, ok := f.containers[name]; ok {
+		return "", fmt.Errorf("failed to create container: the container name %s is already in use", name)
+	}
+
+	env := make(map[string]string, len(config.Env))
+	for key, value := range config.Env {
+		env[key] = value
+	}
+
+	ID := randomContainerID()
+	f.containers[name] = &fakeContainer{
+		container: service.Container{
+			ID:      ID,
+			Image:   config.Image,
+			State:   "created",
+			Name:    "/" + name,
+			Env:     env,
+			Ports:   append([]service.PortBinding(nil), config.Ports...),
+			Volumes: append([]service.VolumeBinding(nil), config.Volumes...),
+		},
+		changed: make(chan struct{}),
+	}
+
+	return ID, nil
+}
+
+func (f *FakeDockerClient) StartContainer(c context.Context, ID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return err
+	}
+
+	if fc.container.Running {
+		return nil
+	}
+
+	if f.StartHandler != nil {
+		if err := f.StartHandler(copyContainer(fc.container)); err != nil {
+			return fmt.Errorf("failed to start container: %v", err)
+		}
+	}
+
+	f.setState(fc, "running")
+	return nil
+}
+
+func (f *FakeDockerClient) StopContainer(c context.Context, ID string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return err
+	}
+
+	if fc.container.Running {
+		f.setState(fc, "exited")
+	}
+	return nil
+}
+
+func (f *FakeDockerClient) RemoveContainer(c context.Context, ID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return err
+	}
+
+	if fc.container.Running {
+		return fmt.Errorf("failed to remove container: container %s is running", ID)
+	}
+
+	for name, other := range f.containers {
+		if other == fc {
+			delete(f.containers, name)
+		}
+	}
+	f.setState(fc, "removed")
+	return nil
+}
+
+func (f *FakeDockerClient) RenameContainer(c context.Context, ID string, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := f.containers[name]; ok {
+		return fmt.Errorf("failed to rename container: the container name %s is already in use", name)
+	}
+
+	for oldName, other := range f.containers {
+		if other == fc {
+			delete(f.containers, oldName)
+		}
+	}
+	f.containers[name] = fc
+	fc.container.Name = "/" + name
+	return nil
+}
+
+// Writes the lines in the same multiplexed format docker uses, with timestamps
+func (f *FakeDockerClient) GetLogs(c context.Context, ID string, options service.LogOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	fc, err := f.get(ID)
+	if err != nil {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("failed to get container logs: %w", err)
+	}
+
+	lines := filterLogs(fc.logs, options)
+	next := len(fc.logs)
+	f.mu.Unlock()
+
+	r, w := io.Pipe()
+	go func() {
+		if err := writeFrames(w, lines, true); err == nil && options.Follow {
+			f.follow(c, fc, next, func(line service.LogLine) error {
+				return writeFrames(w, []service.LogLine{line}, true)
+			})
+		}
+		w.Close()
+	}()
+
+	return r, nil
+}
+
+// Lines written to stdin are logged as the output of the container, new output is sent back without timestamps
+func (f *FakeDockerClient) Attach(c context.Context, ID string) (types.HijackedResponse, error) {
+	f.mu.Lock()
+	fc, err := f.get(ID)
+	if err != nil {
+		f.mu.Unlock()
+		return types.HijackedResponse{}, fmt.Errorf("failed to attach to container: %w", err)
+	}
+	next := len(fc.logs)
+	f.mu.Unlock()
+
+	local, remote := net.Pipe()
+	ctx, cancel := context.WithCancel(c)
+
+	go func() {
+		defer cancel()
+
+		scanner := bufio.NewScanner(remote)
+		for scanner.Scan() {
+			f.mu.Lock()
+			f.log(fc, service.StreamStdout, scanner.Text())
+			f.mu.Unlock()
+		}
+	}()
+
+	go func() {
+		f.follow(ctx, fc, next, func(line service.LogLine) error {
+			return writeFrames(remote, []service.LogLine{line}, false)
+		})
+		remote.Close()
+	}()
+
+	return types.NewHijackedResponse(local, ""), nil
+}
+
+func (f *FakeDockerClient) Exec(c context.Context, ID string, cmd []string) (service.ExecResult, error) {
+	f.mu.Lock()
+	fc, err := f.get(ID)
+	if err == nil && !fc.container.Running {
+		err = fmt.Errorf("failed to create exec instance: container %s is not running", ID)
+	}
+	if err != nil {
+		f.mu.Unlock()
+		return service.ExecResult{}, err
+	}
+	f.Execs = append(f.Execs, append([]string(nil), cmd...))
+	handler := f.ExecHandler
+	f.mu.Unlock()
+
+	if handler != nil {
+		return handler(ID, cmd)
+	}
+
+	return service.ExecResult{Output: []service.LogLine{{Stream: service.StreamStdout, Text: strings.Join(cmd, " ")}}}, nil
+}
+
+func (f *FakeDockerClient) Stats(c context.Context, ID string) (service.ContainerStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, err := f.get(ID)
+	if err != nil {
+		return service.ContainerStats{}, err
+	}
+
+	if !fc.container.Running {
+		return service.ContainerStats{}, nil
+	}
+	return fc.stats, nil
+}
+
+// Containers can be found by name or by ID, like in docker
+func (f *FakeDockerClient) get(ID string) (*fakeContainer, error) {
+	if fc, ok := f.containers[ID]; ok {
+		return fc, nil
+	}
+
+	for _, fc := range f.containers {
+		if fc.container.ID == ID {
+			return fc, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", service.ErrContainerNotFound, ID)
+}
+
+func (f *FakeDockerClient) log(fc *fakeContainer, stream string, text string) {
+	fc.logs = append(fc.logs, service.LogLine{Stream: stream, Timestamp: time.Now().UTC(), Text: text})
+	f.notify(fc)
+}
+
+func (f *FakeDockerClient) setState(fc *fakeContainer, state string) {
+	fc.container.State = state
+	fc.container.Running = state == "running"
+
+	if fc.container.Running {
+		fc.container.IPAddress = "172.17.0.2"
+	} else {
+		fc.container.IPAddress = ""
+	}
+
+	f.notify(fc)
+}
+
+func (f *FakeDockerClient) notify(fc *fakeContainer) {
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+}
+
+// Passes the lines logged from next on to send, until the container is no longer running or the context is done
+func (f *FakeDockerClient) follow(c context.Context, fc *fakeContainer, next int, send func(service.LogLine) error) {
+	for {
+		f.mu.Lock()
+		lines := append([]service.LogLine(nil), fc.logs[next:]...)
+		next = len(fc.logs)
+		running := fc.container.Running
+		changed := fc.changed
+		f.mu.Unlock()
+
+		for _, line := range lines {
+			if err := send(line); err != nil {
+				return
+			}
+		}
+
+		if !running {
+			return
+		}
+
+		select {
+		case <-c.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func filterLogs(logs []service.LogLine, options service.LogOptions) []service.LogLine {
+	var lines []service.LogLine
+
+	since, hasSince := parseSince(options.Since)
+	for _, line := range logs {
+		if !hasSince || !line.Timestamp.Before(since) {
+			lines = append(lines, line)
+		}
+	}
+
+	if tail, err := strconv.Atoi(options.Tail); err == nil && tail >= 0 && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+
+	return lines
+}
+
+// Parses the since option as a unix timestamp with optional nanoseconds, or as RFC3339
+func parseSince(since string) (time.Time, bool) {
+	if since == "" {
+		return time.Time{}, false
+	}
+
+	seconds, nanos, _ := strings.Cut(since, ".")
+	if s, err := strconv.ParseInt(seconds, 10, 64); err == nil {
+		n, _ := strconv.ParseInt((nanos + "000000000")[:9], 10, 64)
+		return time.Unix(s, n), true
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+func writeFrames(w io.Writer, lines []service.LogLine, timestamps bool) error {
+	stdout := stdcopy.NewStdWriter(w, stdcopy.Stdout)
+	stderr := stdcopy.NewStdWriter(w, stdcopy.Stderr)
+
+	for _, line := range lines {
+		text := line.Text + "\n"
+		if timestamps {
+			text = line.Timestamp.Format(time.RFC3339Nano) + " " + text
+		}
+
+		out := stdout
+		if line.Stream == service.StreamStderr {
+			out = stderr
+		}
+
+		if _, err := out.Write([]byte(text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyContainer(container service.Container) service.Container {
+	env := make(map[string]string, len(container.Env))
+	for key, value := range container.Env {
+		env[key] = value
+	}
+
+	container.Env = env
+	container.Ports = append([]service.PortBinding(nil), container.Ports...)
+	container.Volumes = append([]service.VolumeBinding(nil), container.Volumes...)
+	return container
+}
+
+func randomContainerID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,16 +1,26 @@
 package service
 
 import (
-	"errors"
-
-	"github.com/mooncorn/gshub-server-api/cycles"
+	"regexp"
+	"time"
 )
 
+// Valheim does not log players leaving by name, only the SteamID of the closed connection.
+// The connection is logged right before the character of the player, which ties the two together.
+// The character of a player who died is logged again with the id 0:0, which is not a join.
+var valheimLogPatterns = []LogPattern{
+	{Event: EventPlayerConnected, Regex: regexp.MustCompile(`Got connection SteamID (?P<session>[0-9]+)`)},
+	{Event: EventPlayerJoined, Regex: regexp.MustCompile(`Got character ZDOID from (?P<player>.+) : -?[1-9][0-9]*:[0-9]+`)},
+	{Event: EventPlayerLeft, Regex: regexp.MustCompile(`Closing socket (?P<session>[0-9]+)`)},
+	{Event: EventServerReady, Regex: regexp.MustCompile(`Game server connected`)},
+	{Event: EventCrashed, Regex: regexp.MustCompile(`Crash!!!`)},
+}
+
 type ValheimServiceStrategy struct {
-	data *cycles.ServiceData
+	data *InstanceData
 }
 
-func NewValheimServiceStrategy(data *cycles.ServiceData) *ValheimServiceStrategy {
+func NewValheimServiceStrategy(data *InstanceData) *ValheimServiceStrategy {
 	return &ValheimServiceStrategy{
 		data: data,
 	}
@@ -20,6 +30,52 @@ func (s *ValheimServiceStrategy) CreateBaseConfig() map[string]string {
 	return make(map[string]string)
 }
 
-func (s *ValheimServiceStrategy) FormatCommand(cmd string) (string, error) {
-	return "", errors.New("feature not supported")
+func (s *ValheimServiceStrategy) FormatCommand(args []string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *ValheimServiceStrategy) CommandPolicy() CommandPolicy {
+	return CommandPolicy{}
+}
+
+func (s *ValheimServiceStrategy) RconSettings() (RconSettings, error) {
+	return RconSettings{}, ErrNotSupported
+}
+
+func (s *ValheimServiceStrategy) FormatBroadcast(message string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// Valheim saves the world by itself when it receives SIGTERM
+func (s *ValheimServiceStrategy) SaveCommands() []string {
+	return []string{}
+}
+
+// Valheim writes its world atomically on its own schedule, there is no command to pause it
+func (s *ValheimServiceStrategy) PreBackupCommands() []string {
+	return []string{}
+}
+
+func (s *ValheimServiceStrategy) PostBackupCommands() []string {
+	return []string{}
+}
+
+func (s *ValheimServiceStrategy) StopTimeout() time.Duration {
+	return 120 * time.Second
+}
+
+func (s *ValheimServiceStrategy) FormatPlayerCountCommand() (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *ValheimServiceStrategy) ParsePlayerCount(output string) (int, error) {
+	return 0, ErrNotSupported
+}
+
+func (s *ValheimServiceStrategy) LogPatterns() []LogPattern {
+	return valheimLogPatterns
+}
+
+func (s *ValheimServiceStrategy) ModLayout(env map[string]string) (ModLayout, error) {
+	return ModLayout{}, ErrNotSupported
 }